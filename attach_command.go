@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var attachCommand = cli.Command{
+	Name:      "attach",
+	Usage:     "attach to the terminal of a running container",
+	UsageText: `mydocker attach [OPTIONS] CONTAINER`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "detach-keys",
+			Value: DefaultDetachKeys,
+			Usage: "override the key sequence for detaching a container",
+		},
+		cli.BoolFlag{
+			Name:  "no-stdin",
+			Usage: "do not attach STDIN",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 1 {
+			return fmt.Errorf("missing container name")
+		}
+		detachKeys, err := parseDetachKeys(ctx.String("detach-keys"))
+		if err != nil {
+			return err
+		}
+		info, err := FindContainer(ctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		if !info.IsRunning() {
+			return fmt.Errorf("the container `%v` is not running", ctx.Args().Get(0))
+		}
+		if info.Status == StatusPaused {
+			return fmt.Errorf("the container `%v` is paused", ctx.Args().Get(0))
+		}
+		if !info.Tty {
+			return fmt.Errorf("the container `%v` has no terminal to attach to", ctx.Args().Get(0))
+		}
+
+		detached, err := attachContainer(info.Name, !ctx.Bool("no-stdin"), detachKeys)
+		if err != nil || detached {
+			return err
+		}
+		exitCode, err := waitContainer(info.Name)
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return cli.NewExitError("", exitCode)
+		}
+		return nil
+	},
+}
+
+// attachContainer connects our terminal to the console of the container until
+// the container exits or the detach keys are typed, and reports whether we
+// detached.
+func attachContainer(containerName string, withStdin bool, detachKeys []byte) (bool, error) {
+	console, err := DialConsole(containerName)
+	if err != nil {
+		return false, err
+	}
+	defer console.Close()
+	return attachConsole(console, withStdin, detachKeys)
+}
+
+// attachConsole is attachContainer with a console already dialed.
+func attachConsole(console *ConsoleClient, withStdin bool, detachKeys []byte) (bool, error) {
+	terminal := os.Stdout.Fd()
+	if withStdin {
+		terminal = os.Stdin.Fd()
+	}
+	if isTerminal(terminal) {
+		if ws, err := getWinsize(terminal); err == nil {
+			console.Resize(ws)
+		}
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		defer signal.Stop(winch)
+		go func() {
+			for range winch {
+				if ws, err := getWinsize(terminal); err == nil {
+					console.Resize(ws)
+				}
+			}
+		}()
+		if withStdin {
+			oldState, err := makeRaw(terminal)
+			if err != nil {
+				return false, err
+			}
+			defer restoreTerminal(terminal, oldState)
+		}
+	}
+
+	outputDone := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(os.Stdout, console)
+		outputDone <- err
+	}()
+	detached := make(chan struct{})
+	if withStdin {
+		go func() {
+			if ok, _ := copyUntilDetach(console, os.Stdin, detachKeys); ok {
+				close(detached)
+			}
+		}()
+	}
+
+	select {
+	case err := <-outputDone:
+		return false, err
+	case <-detached:
+		return true, nil
+	}
+}
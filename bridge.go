@@ -89,7 +89,20 @@ func (b *BridgeDriver) Delete(network Network) error {
 	if output, err := cmd.Output(); err != nil {
 		return fmt.Errorf("iptables failed: output=%v, err=%w", output, err)
 	}
-	return nil
+
+	// The rules of the published ports, set up by the first container
+	// publishing some on the bridge.
+	networks, err := ListNetwork()
+	if err != nil {
+		return err
+	}
+	lastBridge := true
+	for _, other := range networks {
+		if other.Name != network.Name && other.Driver == b.Name() {
+			lastBridge = false
+		}
+	}
+	return tearDownPortMappingChain(network.Name, lastBridge)
 }
 
 func (b *BridgeDriver) Connect(network Network, container *Container) error {
@@ -113,12 +126,26 @@ func (b *BridgeDriver) Connect(network Network, container *Container) error {
 	if err = netlink.LinkSetUp(&veth); err != nil {
 		return err
 	}
-	return nil
+	// Let a container reach its own published ports, which sends the
+	// packets back out of the bridge port they came in on.
+	if len(container.ports) > 0 {
+		if err = netlink.LinkSetHairpin(&veth, true); err != nil {
+			return err
+		}
+	}
+	return addPortMappings(network.Name, container.ports, container.ip)
 }
 
 func (b *BridgeDriver) Disconnect(container Container) error {
+	if err := removePortMappings(container.ports, container.ip); err != nil {
+		return err
+	}
 	veth, err := netlink.LinkByName(makeVethName(container.id))
 	if err != nil {
+		// The veth pair goes away with the container's network namespace.
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
 		return err
 	}
 	if err := netlink.LinkSetDown(veth); err != nil {
@@ -2,24 +2,167 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 type Cgroup struct {
 	id string
 }
 
+// SubsystemConfig is the resources of a container, also kept in its state
+// so that they can be updated.
 type SubsystemConfig struct {
-	cpuShare  int
-	memory    string
-	cpuPeriod int
-	cpuQuota  int
-	cpuSet    string
-	cpus      float64
+	CpuShare  int
+	CpuPeriod int
+	CpuQuota  int
+	CpuSet    string
+	Cpus      float64
+	// The memory sizes are in bytes; 0 means not set, and -1 unlimited for
+	// MemorySwap.
+	Memory            int64
+	MemorySwap        int64
+	MemoryReservation int64
+	KernelMemory      int64
+	OomKillDisable    bool
+	// 0 means not set, and -1 unlimited.
+	PidsLimit int64
+	// The weight is in [10, 1000] as with cgroup v1, 0 meaning not set.
+	BlkioWeight          int
+	BlkioDeviceReadBps   []ThrottleDevice
+	BlkioDeviceWriteBps  []ThrottleDevice
+	BlkioDeviceReadIOps  []ThrottleDevice
+	BlkioDeviceWriteIOps []ThrottleDevice
+	// The devices allowed, all the others being denied; nil means all are
+	// allowed.
+	Devices []Device
+}
+
+// ThrottleDevice is a rate limit, in bytes or operations per second, on a
+// block device.
+type ThrottleDevice struct {
+	Major int64
+	Minor int64
+	Rate  uint64
+}
+
+// parseThrottleDevice parses a limit like /dev/sda:1mb, where the rate is a
+// size for a limit in bytes and an integer for one in operations.
+func parseThrottleDevice(s string, isBytes bool) (ThrottleDevice, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return ThrottleDevice{}, fmt.Errorf("bad format `%v`, expected DEVICE:RATE", s)
+	}
+	device := ThrottleDevice{}
+	var err error
+	if device.Major, device.Minor, err = blockDeviceNumbers(s[:i]); err != nil {
+		return ThrottleDevice{}, err
+	}
+	var rate int64
+	if isBytes {
+		rate, err = parseBytes(s[i+1:])
+	} else {
+		rate, err = strconv.ParseInt(s[i+1:], 10, 64)
+	}
+	if err != nil || rate <= 0 {
+		return ThrottleDevice{}, fmt.Errorf("bad rate `%v`", s[i+1:])
+	}
+	device.Rate = uint64(rate)
+	return device, nil
+}
+
+// blockDeviceNumbers returns the major and minor numbers of a block device.
+func blockDeviceNumbers(devicePath string) (int64, int64, error) {
+	fileInfo, err := os.Stat(devicePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	if fileInfo.Mode()&os.ModeDevice == 0 || fileInfo.Mode()&os.ModeCharDevice != 0 {
+		return 0, 0, fmt.Errorf("%v is not a block device", devicePath)
+	}
+	major, minor := splitDeviceNumber(fileInfo.Sys().(*syscall.Stat_t).Rdev)
+	return major, minor, nil
+}
+
+// splitDeviceNumber decodes a dev_t as glibc's gnu_dev_major and
+// gnu_dev_minor do.
+func splitDeviceNumber(dev uint64) (int64, int64) {
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	return int64(major), int64(minor)
+}
+
+// The smallest memory limit we allow, below which a container can hardly
+// start.
+const MinMemoryLimit = 6 * 1024 * 1024
+
+// Validate checks the config for what the kernel would reject with a less
+// helpful EINVAL.
+func (c SubsystemConfig) Validate() error {
+	if c.Memory < 0 || c.MemoryReservation < 0 || c.KernelMemory < 0 || c.MemorySwap < -1 {
+		return fmt.Errorf("memory sizes can't be negative")
+	}
+	if c.Memory != 0 && c.Memory < MinMemoryLimit {
+		return fmt.Errorf("the minimum memory limit allowed is 6MB")
+	}
+	if c.KernelMemory != 0 && c.KernelMemory < MinMemoryLimit {
+		return fmt.Errorf("the minimum kernel memory limit allowed is 6MB")
+	}
+	if c.MemorySwap != 0 {
+		if c.Memory == 0 {
+			return fmt.Errorf("a memory limit must be set along with the memory swap limit")
+		}
+		if c.MemorySwap != -1 && c.MemorySwap < c.Memory {
+			return fmt.Errorf("the memory swap limit must be larger than the memory limit")
+		}
+	}
+	if c.Memory != 0 && c.MemoryReservation > c.Memory {
+		return fmt.Errorf("the memory limit must be larger than the memory reservation")
+	}
+	if c.PidsLimit < -1 {
+		return fmt.Errorf("the pids limit can't be negative")
+	}
+	if c.BlkioWeight != 0 && (c.BlkioWeight < 10 || c.BlkioWeight > 1000) {
+		return fmt.Errorf("the blkio weight must be in the range [10, 1000]")
+	}
+	return nil
+}
+
+// parseBytes parses a size like 512, 100k, 64m or 1g, with an optional b
+// suffix, e.g. 64mb.
+func parseBytes(s string) (int64, error) {
+	str := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "b")
+	if str == "-1" {
+		return -1, nil
+	}
+	unit := int64(1)
+	if len(str) > 0 {
+		switch str[len(str)-1] {
+		case 'k':
+			unit = 1 << 10
+		case 'm':
+			unit = 1 << 20
+		case 'g':
+			unit = 1 << 30
+		case 't':
+			unit = 1 << 40
+		}
+		if unit != 1 {
+			str = str[0 : len(str)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad size `%v`", s)
+	}
+	return int64(n * float64(unit)), nil
 }
 
 func NewCgroup(id string) *Cgroup {
@@ -29,6 +172,14 @@ func NewCgroup(id string) *Cgroup {
 }
 
 func (c *Cgroup) Set(config SubsystemConfig) error {
+	mode, err := GetCgroupMode()
+	if err != nil {
+		return err
+	}
+	if mode == CgroupUnified {
+		return c.setUnified(config)
+	}
+
 	for _, subsys := range subsystems {
 		err := subsys.Set(c.id, config)
 		if err != nil {
@@ -39,6 +190,14 @@ func (c *Cgroup) Set(config SubsystemConfig) error {
 }
 
 func (c *Cgroup) Apply(pid int) error {
+	mode, err := GetCgroupMode()
+	if err != nil {
+		return err
+	}
+	if mode == CgroupUnified {
+		return c.applyUnified(pid)
+	}
+
 	for _, subsys := range subsystems {
 		err := Apply(subsys, c.id, pid)
 		if err != nil {
@@ -48,7 +207,50 @@ func (c *Cgroup) Apply(pid int) error {
 	return nil
 }
 
+// ProcsPaths returns the cgroup.procs files through which a process joins
+// the cgroup, one for each hierarchy with cgroup v1.
+func (c *Cgroup) ProcsPaths() ([]string, error) {
+	mode, err := GetCgroupMode()
+	if err != nil {
+		return nil, err
+	}
+	if mode == CgroupUnified {
+		cgroupPath, err := GetUnifiedCgroupPath(c.id)
+		if err != nil {
+			return nil, err
+		}
+		return []string{path.Join(cgroupPath, "cgroup.procs")}, nil
+	}
+
+	var paths []string
+	seen := make(map[string]bool)
+	for _, subsys := range subsystems {
+		cgroupPath, err := GetCgroupPath(subsys.Name(), c.id)
+		if errors.Is(err, errSubsystemNotMounted) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// As with Apply, the cgroups not created are skipped.
+		if _, err := os.Stat(cgroupPath); err != nil || seen[cgroupPath] {
+			continue
+		}
+		seen[cgroupPath] = true
+		paths = append(paths, path.Join(cgroupPath, "cgroup.procs"))
+	}
+	return paths, nil
+}
+
 func (c *Cgroup) Destroy() error {
+	mode, err := GetCgroupMode()
+	if err != nil {
+		return err
+	}
+	if mode == CgroupUnified {
+		return c.destroyUnified()
+	}
+
 	for _, subsys := range subsystems {
 		err := Destroy(subsys, c.id)
 		if err != nil {
@@ -58,6 +260,21 @@ func (c *Cgroup) Destroy() error {
 	return nil
 }
 
+// Path returns the directory holding the files of the subsystem, which is
+// the same for all the subsystems with cgroup v2.
+func (c *Cgroup) Path(subsystemName string) (string, error) {
+	mode, err := GetCgroupMode()
+	if err != nil {
+		return "", err
+	}
+	if mode == CgroupUnified {
+		return GetUnifiedCgroupPath(c.id)
+	}
+	return GetCgroupPath(subsystemName, c.id)
+}
+
+var errSubsystemNotMounted = errors.New("cgroup subsystem is not mounted")
+
 func GetCgroupPath(subsystemName string, id string) (string, error) {
 	root, err := FindCgroupRoot(subsystemName)
 	if err != nil {
@@ -66,28 +283,65 @@ func GetCgroupPath(subsystemName string, id string) (string, error) {
 	return path.Join(root, id), nil
 }
 
+// FindCgroupRoot finds where the hierarchy of the subsystem is mounted with
+// cgroup v1.
 func FindCgroupRoot(subsystemName string) (string, error) {
+	mounts, err := readMountInfo()
+	if err != nil {
+		return "", err
+	}
+	for _, m := range mounts {
+		if m.fsType != "cgroup" {
+			continue
+		}
+		for _, opt := range m.superOptions {
+			if opt == subsystemName {
+				return m.mountPoint, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: %v", errSubsystemNotMounted, subsystemName)
+}
+
+type mountInfo struct {
+	mountPoint   string
+	fsType       string
+	superOptions []string
+}
+
+func readMountInfo() ([]mountInfo, error) {
 	f, err := os.Open("/proc/self/mountinfo")
 	if err != nil {
-		return "", nil
+		return nil, err
 	}
 	defer f.Close()
 
+	var mounts []mountInfo
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
-		txt := scanner.Text()
-		fields := strings.Split(txt, " ")
-		for _, opt := range strings.Split(fields[len(fields)-1], ",") {
-			if opt == subsystemName {
-				return fields[4], nil
-			}
+		// The optional fields before the separator vary in number.
+		fields := strings.Split(scanner.Text(), " ")
+		sep := 6
+		for sep < len(fields) && fields[sep] != "-" {
+			sep++
+		}
+		if sep+3 >= len(fields) {
+			continue
 		}
+		mounts = append(mounts, mountInfo{
+			mountPoint:   fields[4],
+			fsType:       fields[sep+1],
+			superOptions: strings.Split(fields[sep+3], ","),
+		})
 	}
-	return "", scanner.Err()
+	return mounts, scanner.Err()
 }
 
 func Apply(sys Subsystem, id string, pid int) error {
 	cgroupPath, err := GetCgroupPath(sys.Name(), id)
+	if errors.Is(err, errSubsystemNotMounted) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -103,6 +357,9 @@ func Apply(sys Subsystem, id string, pid int) error {
 
 func Destroy(sys Subsystem, id string) error {
 	cgroupPath, err := GetCgroupPath(sys.Name(), id)
+	if errors.Is(err, errSubsystemNotMounted) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -111,13 +368,25 @@ func Destroy(sys Subsystem, id string) error {
 
 type Subsystem interface {
 	Name() string
+	// Controller is the name of the subsystem with cgroup v2, or empty if
+	// it needs no controller to be enabled.
+	Controller() string
 	Set(id string, config SubsystemConfig) error
+	// SetUnified is Set for cgroup v2, where all the subsystems share the
+	// one directory.
+	SetUnified(cgroupPath string, config SubsystemConfig) error
 }
 
 var (
 	subsystems = []Subsystem{
 		&CpuSubsystem{},
+		&CpuacctSubsystem{},
 		&CpusetSubsystem{},
+		&MemorySubsystem{},
+		&PidsSubsystem{},
+		&BlkioSubsystem{},
+		&DevicesSubsystem{},
+		&FreezerSubsystem{},
 	}
 )
 
@@ -128,16 +397,23 @@ func (c *CpuSubsystem) Name() string {
 	return "cpu"
 }
 
+func (c *CpuSubsystem) Controller() string {
+	return "cpu"
+}
+
+// Set creates the cgroup even without a limit, so that the container is in it
+// when a limit is set by `mydocker update`.
 func (c *CpuSubsystem) Set(id string, config SubsystemConfig) error {
-	if config.cpuShare == 0 && config.cpuPeriod == 0 && config.cpuQuota == 0 && config.cpus == 0 {
-		return nil
-	}
-	if config.cpus != 0 {
-		config.cpuPeriod = 1000000
-		config.cpuQuota = int(config.cpus * float64(config.cpuPeriod))
+	hasLimits := config.CpuShare != 0 || config.CpuPeriod != 0 || config.CpuQuota != 0 || config.Cpus != 0
+	if config.Cpus != 0 {
+		config.CpuPeriod = 1000000
+		config.CpuQuota = int(config.Cpus * float64(config.CpuPeriod))
 	}
 
 	cgroupPath, err := GetCgroupPath(c.Name(), id)
+	if errors.Is(err, errSubsystemNotMounted) && !hasLimits {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -145,18 +421,18 @@ func (c *CpuSubsystem) Set(id string, config SubsystemConfig) error {
 		return err
 	}
 
-	if config.cpuShare != 0 {
-		if err := ioutil.WriteFile(path.Join(cgroupPath, "cpu.shares"), []byte(strconv.Itoa(config.cpuShare)), 0644); err != nil {
+	if config.CpuShare != 0 {
+		if err := ioutil.WriteFile(path.Join(cgroupPath, "cpu.shares"), []byte(strconv.Itoa(config.CpuShare)), 0644); err != nil {
 			return err
 		}
 	}
-	if config.cpuPeriod != 0 {
-		if err := ioutil.WriteFile(path.Join(cgroupPath, "cpu.cfs_period_us"), []byte(strconv.Itoa(config.cpuPeriod)), 0644); err != nil {
+	if config.CpuPeriod != 0 {
+		if err := ioutil.WriteFile(path.Join(cgroupPath, "cpu.cfs_period_us"), []byte(strconv.Itoa(config.CpuPeriod)), 0644); err != nil {
 			return err
 		}
 	}
-	if config.cpuQuota != 0 {
-		if err := ioutil.WriteFile(path.Join(cgroupPath, "cpu.cfs_quota_us"), []byte(strconv.Itoa(config.cpuQuota)), 0644); err != nil {
+	if config.CpuQuota != 0 {
+		if err := ioutil.WriteFile(path.Join(cgroupPath, "cpu.cfs_quota_us"), []byte(strconv.Itoa(config.CpuQuota)), 0644); err != nil {
 			return err
 		}
 	}
@@ -164,6 +440,74 @@ func (c *CpuSubsystem) Set(id string, config SubsystemConfig) error {
 	return nil
 }
 
+func (c *CpuSubsystem) SetUnified(cgroupPath string, config SubsystemConfig) error {
+	if config.Cpus != 0 {
+		config.CpuPeriod = 1000000
+		config.CpuQuota = int(config.Cpus * float64(config.CpuPeriod))
+	}
+
+	if config.CpuShare != 0 {
+		if err := writeCgroupFile(cgroupPath, "cpu.weight", strconv.Itoa(cpuSharesToWeight(config.CpuShare))); err != nil {
+			return err
+		}
+	}
+	if config.CpuPeriod != 0 || config.CpuQuota != 0 {
+		quota := "max"
+		if config.CpuQuota > 0 {
+			quota = strconv.Itoa(config.CpuQuota)
+		}
+		period := config.CpuPeriod
+		if period == 0 {
+			period = 100000
+		}
+		if err := writeCgroupFile(cgroupPath, "cpu.max", fmt.Sprintf("%s %d", quota, period)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cpuSharesToWeight maps cpu.shares in [2, 262144] to cpu.weight in [1,
+// 10000].
+func cpuSharesToWeight(shares int) int {
+	if shares < 2 {
+		shares = 2
+	}
+	if shares > 262144 {
+		shares = 262144
+	}
+	return 1 + (shares-2)*9999/262142
+}
+
+type CpuacctSubsystem struct {
+}
+
+func (c *CpuacctSubsystem) Name() string {
+	return "cpuacct"
+}
+
+// Controller is empty since cpu.stat is in every cgroup with cgroup v2.
+func (c *CpuacctSubsystem) Controller() string {
+	return ""
+}
+
+// Set creates the cgroup so that the CPU usage of the container can be
+// read.
+func (c *CpuacctSubsystem) Set(id string, config SubsystemConfig) error {
+	cgroupPath, err := GetCgroupPath(c.Name(), id)
+	if errors.Is(err, errSubsystemNotMounted) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(cgroupPath, 0755)
+}
+
+func (c *CpuacctSubsystem) SetUnified(cgroupPath string, config SubsystemConfig) error {
+	return nil
+}
+
 type CpusetSubsystem struct {
 }
 
@@ -171,25 +515,63 @@ func (c *CpusetSubsystem) Name() string {
 	return "cpuset"
 }
 
+func (c *CpusetSubsystem) Controller() string {
+	return "cpuset"
+}
+
+// Set creates the cgroup even without a limit, so that the container is in it
+// when a limit is set by `mydocker update`.
 func (c *CpusetSubsystem) Set(id string, config SubsystemConfig) error {
-	if config.cpuSet == "" {
+	cgroupPath, err := GetCgroupPath(c.Name(), id)
+	if errors.Is(err, errSubsystemNotMounted) && config.CpuSet == "" {
 		return nil
 	}
-
-	cgroupPath, err := GetCgroupPath(c.Name(), id)
 	if err != nil {
 		return err
 	}
 	if err := os.MkdirAll(cgroupPath, 0755); err != nil {
 		return err
 	}
+	// No process can join a cpuset cgroup without CPUs or memory nodes,
+	// which a new one has unless the parent has cgroup.clone_children set.
+	for _, name := range []string{"cpuset.cpus", "cpuset.mems"} {
+		if err := inheritCgroupFile(cgroupPath, name); err != nil {
+			return err
+		}
+	}
 
-	if err := ioutil.WriteFile(path.Join(cgroupPath, "cpuset.cpus"), []byte(config.cpuSet), 0644); err != nil {
+	if config.CpuSet == "" {
+		return nil
+	}
+	if err := ioutil.WriteFile(path.Join(cgroupPath, "cpuset.cpus"), []byte(config.CpuSet), 0644); err != nil {
 		return err
 	}
 	return nil
 }
 
+// inheritCgroupFile copies the value of the file from the parent cgroup if
+// it's empty.
+func inheritCgroupFile(cgroupPath, name string) error {
+	value, err := ioutil.ReadFile(path.Join(cgroupPath, name))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(value)) != "" {
+		return nil
+	}
+	if value, err = ioutil.ReadFile(path.Join(path.Dir(cgroupPath), name)); err != nil {
+		return err
+	}
+	return writeCgroupFile(cgroupPath, name, strings.TrimSpace(string(value)))
+}
+
+func (c *CpusetSubsystem) SetUnified(cgroupPath string, config SubsystemConfig) error {
+	if config.CpuSet == "" {
+		return nil
+	}
+	return writeCgroupFile(cgroupPath, "cpuset.cpus", config.CpuSet)
+}
+
 type MemorySubsystem struct {
 }
 
@@ -197,21 +579,419 @@ func (m *MemorySubsystem) Name() string {
 	return "memory"
 }
 
+func (m *MemorySubsystem) Controller() string {
+	return "memory"
+}
+
+// Set creates the cgroup even without a limit, so that the memory usage of
+// the container can always be read.
 func (m *MemorySubsystem) Set(id string, config SubsystemConfig) error {
-	if config.memory == "" {
+	hasLimits := config.Memory != 0 || config.MemorySwap != 0 || config.MemoryReservation != 0 || config.KernelMemory != 0 || config.OomKillDisable
+	cgroupPath, err := GetCgroupPath(m.Name(), id)
+	if errors.Is(err, errSubsystemNotMounted) && !hasLimits {
 		return nil
 	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cgroupPath, 0755); err != nil {
+		return err
+	}
 
-	cgroupPath, err := GetCgroupPath(m.Name(), id)
+	// The swap limit can't be lower than the memory limit at any time, so
+	// it goes first when raised above the current memory limit and last
+	// otherwise.
+	files := []struct {
+		name  string
+		value int64
+	}{
+		{"memory.limit_in_bytes", config.Memory},
+		{"memory.memsw.limit_in_bytes", config.MemorySwap},
+		{"memory.soft_limit_in_bytes", config.MemoryReservation},
+		{"memory.kmem.limit_in_bytes", config.KernelMemory},
+	}
+	if config.MemorySwap != 0 {
+		current, err := readCgroupInt(cgroupPath, "memory.limit_in_bytes")
+		if err != nil {
+			return err
+		}
+		if config.MemorySwap == -1 || config.MemorySwap > current {
+			files[0], files[1] = files[1], files[0]
+		}
+	}
+	for _, file := range files {
+		if file.value == 0 {
+			continue
+		}
+		if err := writeCgroupFile(cgroupPath, file.name, strconv.FormatInt(file.value, 10)); err != nil {
+			return err
+		}
+	}
+	if config.OomKillDisable {
+		if err := writeCgroupFile(cgroupPath, "memory.oom_control", "1"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemorySubsystem) SetUnified(cgroupPath string, config SubsystemConfig) error {
+	if config.KernelMemory != 0 {
+		return fmt.Errorf("kernel memory limits are not supported with cgroup v2")
+	}
+	if config.OomKillDisable {
+		return fmt.Errorf("disabling the OOM killer is not supported with cgroup v2")
+	}
+
+	if config.Memory != 0 {
+		if err := writeCgroupFile(cgroupPath, "memory.max", strconv.FormatInt(config.Memory, 10)); err != nil {
+			return err
+		}
+	}
+	// memory.swap.max limits the swap alone rather than memory plus swap.
+	if config.MemorySwap == -1 {
+		if err := writeCgroupFile(cgroupPath, "memory.swap.max", "max"); err != nil {
+			return err
+		}
+	} else if config.MemorySwap != 0 {
+		if err := writeCgroupFile(cgroupPath, "memory.swap.max", strconv.FormatInt(config.MemorySwap-config.Memory, 10)); err != nil {
+			return err
+		}
+	}
+	if config.MemoryReservation != 0 {
+		if err := writeCgroupFile(cgroupPath, "memory.low", strconv.FormatInt(config.MemoryReservation, 10)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type PidsSubsystem struct {
+}
+
+func (p *PidsSubsystem) Name() string {
+	return "pids"
+}
+
+func (p *PidsSubsystem) Controller() string {
+	return "pids"
+}
+
+// Set creates the cgroup even without a limit, so that the number of
+// processes in the container can always be read.
+func (p *PidsSubsystem) Set(id string, config SubsystemConfig) error {
+	cgroupPath, err := GetCgroupPath(p.Name(), id)
+	if errors.Is(err, errSubsystemNotMounted) && config.PidsLimit == 0 {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 	if err := os.MkdirAll(cgroupPath, 0755); err != nil {
 		return err
 	}
+	return p.SetUnified(cgroupPath, config)
+}
+
+// pids.max is the same file with cgroup v1 and v2.
+func (p *PidsSubsystem) SetUnified(cgroupPath string, config SubsystemConfig) error {
+	switch config.PidsLimit {
+	case 0:
+		return nil
+	case -1:
+		return writeCgroupFile(cgroupPath, "pids.max", "max")
+	}
+	return writeCgroupFile(cgroupPath, "pids.max", strconv.FormatInt(config.PidsLimit, 10))
+}
+
+type BlkioSubsystem struct {
+}
+
+func (b *BlkioSubsystem) Name() string {
+	return "blkio"
+}
+
+func (b *BlkioSubsystem) Controller() string {
+	return "io"
+}
 
-	if err := ioutil.WriteFile(path.Join(cgroupPath, "memory.limit_in_bytes"), []byte(config.memory), 0644); err != nil {
+// Set creates the cgroup even without a limit, so that the I/O of the
+// container can always be read.
+func (b *BlkioSubsystem) Set(id string, config SubsystemConfig) error {
+	hasLimits := config.BlkioWeight != 0 || len(config.BlkioDeviceReadBps) != 0 || len(config.BlkioDeviceWriteBps) != 0 ||
+		len(config.BlkioDeviceReadIOps) != 0 || len(config.BlkioDeviceWriteIOps) != 0
+	cgroupPath, err := GetCgroupPath(b.Name(), id)
+	if errors.Is(err, errSubsystemNotMounted) && !hasLimits {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
+	if err := os.MkdirAll(cgroupPath, 0755); err != nil {
+		return err
+	}
+
+	if config.BlkioWeight != 0 {
+		// Only the BFQ scheduler has a weight file with recent kernels.
+		name := "blkio.weight"
+		if _, err := os.Stat(path.Join(cgroupPath, name)); os.IsNotExist(err) {
+			name = "blkio.bfq.weight"
+		}
+		if err := writeCgroupFile(cgroupPath, name, strconv.Itoa(config.BlkioWeight)); err != nil {
+			return err
+		}
+	}
+	files := []struct {
+		name    string
+		devices []ThrottleDevice
+	}{
+		{"blkio.throttle.read_bps_device", config.BlkioDeviceReadBps},
+		{"blkio.throttle.write_bps_device", config.BlkioDeviceWriteBps},
+		{"blkio.throttle.read_iops_device", config.BlkioDeviceReadIOps},
+		{"blkio.throttle.write_iops_device", config.BlkioDeviceWriteIOps},
+	}
+	for _, file := range files {
+		// Each write sets the limit on one device.
+		for _, device := range file.devices {
+			value := fmt.Sprintf("%d:%d %d", device.Major, device.Minor, device.Rate)
+			if err := writeCgroupFile(cgroupPath, file.name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *BlkioSubsystem) SetUnified(cgroupPath string, config SubsystemConfig) error {
+	if config.BlkioWeight != 0 {
+		weight := blkioWeightToIOWeight(config.BlkioWeight)
+		if err := writeCgroupFile(cgroupPath, "io.weight", fmt.Sprintf("default %d", weight)); err != nil {
+			return err
+		}
+	}
+	limits := []struct {
+		key     string
+		devices []ThrottleDevice
+	}{
+		{"rbps", config.BlkioDeviceReadBps},
+		{"wbps", config.BlkioDeviceWriteBps},
+		{"riops", config.BlkioDeviceReadIOps},
+		{"wiops", config.BlkioDeviceWriteIOps},
+	}
+	for _, limit := range limits {
+		// The limits not given in a write to io.max are left as they are.
+		for _, device := range limit.devices {
+			value := fmt.Sprintf("%d:%d %s=%d", device.Major, device.Minor, limit.key, device.Rate)
+			if err := writeCgroupFile(cgroupPath, "io.max", value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// blkioWeightToIOWeight maps blkio.weight in [10, 1000] to io.weight in [1,
+// 10000].
+func blkioWeightToIOWeight(weight int) int {
+	return 1 + (weight-10)*9999/990
+}
+
+type DevicesSubsystem struct {
+}
+
+func (d *DevicesSubsystem) Name() string {
+	return "devices"
+}
+
+// Controller is empty since the devices are allowed by an eBPF program
+// with cgroup v2.
+func (d *DevicesSubsystem) Controller() string {
+	return ""
+}
+
+func (d *DevicesSubsystem) Set(id string, config SubsystemConfig) error {
+	if config.Devices == nil {
+		return nil
+	}
+
+	cgroupPath, err := GetCgroupPath(d.Name(), id)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cgroupPath, 0755); err != nil {
+		return err
+	}
+
+	if err := writeCgroupFile(cgroupPath, "devices.deny", "a"); err != nil {
+		return err
+	}
+	for _, device := range config.Devices {
+		if err := writeCgroupFile(cgroupPath, "devices.allow", device.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DevicesSubsystem) SetUnified(cgroupPath string, config SubsystemConfig) error {
+	if config.Devices == nil {
+		return nil
+	}
+	return attachDeviceFilter(cgroupPath, config.Devices)
+}
+
+type FreezerSubsystem struct {
+}
+
+func (f *FreezerSubsystem) Name() string {
+	return "freezer"
+}
+
+// Controller is empty since cgroup.freeze is in every cgroup with cgroup v2.
+func (f *FreezerSubsystem) Controller() string {
+	return ""
+}
+
+// Set creates the cgroup so that the container can be frozen later.
+func (f *FreezerSubsystem) Set(id string, config SubsystemConfig) error {
+	cgroupPath, err := GetCgroupPath(f.Name(), id)
+	if errors.Is(err, errSubsystemNotMounted) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(cgroupPath, 0755)
+}
+
+func (f *FreezerSubsystem) SetUnified(cgroupPath string, config SubsystemConfig) error {
+	return nil
+}
+
+// How long to wait for the kernel to freeze or thaw all the processes.
+const FreezeTimeout = 10 * time.Second
+
+// Freeze stops all the processes in the cgroup, and returns once they are
+// all stopped.
+func (c *Cgroup) Freeze() error {
+	return c.setFrozen(true)
+}
+
+func (c *Cgroup) Thaw() error {
+	return c.setFrozen(false)
+}
+
+func (c *Cgroup) setFrozen(frozen bool) error {
+	mode, err := GetCgroupMode()
+	if err != nil {
+		return err
+	}
+	cgroupPath, err := c.Path("freezer")
+	if err != nil {
+		return err
+	}
+
+	// With cgroup v1, freezer.state goes through FREEZING, and freezing
+	// may need another try when processes are forking.
+	isDone := func() (bool, error) {
+		state := "THAWED"
+		if frozen {
+			state = "FROZEN"
+		}
+		if err := writeCgroupFile(cgroupPath, "freezer.state", state); err != nil {
+			return false, err
+		}
+		data, err := ioutil.ReadFile(path.Join(cgroupPath, "freezer.state"))
+		return strings.TrimSpace(string(data)) == state, err
+	}
+	if mode == CgroupUnified {
+		value := "0"
+		if frozen {
+			value = "1"
+		}
+		if err := writeCgroupFile(cgroupPath, "cgroup.freeze", value); err != nil {
+			return err
+		}
+		// cgroup.events tells when the freezing is complete.
+		isDone = func() (bool, error) {
+			data, err := ioutil.ReadFile(path.Join(cgroupPath, "cgroup.events"))
+			if err != nil {
+				return false, err
+			}
+			for _, line := range strings.Split(string(data), "\n") {
+				if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "frozen" {
+					return fields[1] == value, nil
+				}
+			}
+			return false, fmt.Errorf("cgroup.events has no frozen state")
+		}
+	}
+
+	deadline := time.Now().Add(FreezeTimeout)
+	for {
+		done, err := isDone()
+		if err != nil || done {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for the cgroup to be frozen or thawed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// PidsCurrent returns the number of processes in the cgroup.
+func (c *Cgroup) PidsCurrent() (int64, error) {
+	cgroupPath, err := c.Path("pids")
+	if err != nil {
+		return 0, err
+	}
+	return readCgroupInt(cgroupPath, "pids.current")
+}
+
+// MemoryUsage returns the memory used by the cgroup, less the inactive page
+// cache which the kernel reclaims to meet a lower limit, as in the stats.
+func (c *Cgroup) MemoryUsage() (uint64, error) {
+	mode, err := GetCgroupMode()
+	if err != nil {
+		return 0, err
+	}
+	cgroupPath, err := c.Path("memory")
+	if err != nil {
+		return 0, err
+	}
+	name, inactiveFile := "memory.usage_in_bytes", "total_inactive_file"
+	if mode == CgroupUnified {
+		name, inactiveFile = "memory.current", "inactive_file"
+	}
+	usage, err := readCgroupInt(cgroupPath, name)
+	if err != nil {
+		return 0, err
+	}
+	memoryStat, err := readCgroupKeyValues(cgroupPath, "memory.stat")
+	if err != nil {
+		return 0, err
+	}
+	return subtractUint(uint64(usage), memoryStat[inactiveFile]), nil
+}
+
+func readCgroupInt(cgroupPath, name string) (int64, error) {
+	data, err := ioutil.ReadFile(path.Join(cgroupPath, name))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// writeCgroupFile writes a control file, telling a file unsupported by the
+// kernel from a rejected value.
+func writeCgroupFile(cgroupPath, name, value string) error {
+	err := ioutil.WriteFile(path.Join(cgroupPath, name), []byte(value), 0644)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%v is not supported by the kernel", name)
+	}
+	if err != nil {
+		return fmt.Errorf("can't write `%v` to %v: %w", value, name, err)
+	}
 	return nil
 }
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// CgroupStats is the resource usage of a cgroup.
+type CgroupStats struct {
+	// The CPU time used, in nanoseconds.
+	CpuUsage    uint64
+	MemoryUsage uint64
+	// The memory limit, which is the memory of the host if not set.
+	MemoryLimit uint64
+	Pids        int64
+	BlockRead   uint64
+	BlockWrite  uint64
+}
+
+func (c *Cgroup) Stats() (*CgroupStats, error) {
+	mode, err := GetCgroupMode()
+	if err != nil {
+		return nil, err
+	}
+	stats := &CgroupStats{}
+	if stats.Pids, err = c.PidsCurrent(); err != nil {
+		return nil, err
+	}
+	if mode == CgroupUnified {
+		err = c.readUnifiedStats(stats)
+	} else {
+		err = c.readStats(stats)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var info syscall.Sysinfo_t
+	if err := syscall.Sysinfo(&info); err != nil {
+		return nil, err
+	}
+	if total := info.Totalram * uint64(info.Unit); stats.MemoryLimit == 0 || stats.MemoryLimit > total {
+		stats.MemoryLimit = total
+	}
+	return stats, nil
+}
+
+func (c *Cgroup) readStats(stats *CgroupStats) error {
+	cgroupPath, err := GetCgroupPath("cpuacct", c.id)
+	if err != nil {
+		return err
+	}
+	usage, err := readCgroupInt(cgroupPath, "cpuacct.usage")
+	if err != nil {
+		return err
+	}
+	stats.CpuUsage = uint64(usage)
+
+	if cgroupPath, err = GetCgroupPath("memory", c.id); err != nil {
+		return err
+	}
+	if usage, err = readCgroupInt(cgroupPath, "memory.usage_in_bytes"); err != nil {
+		return err
+	}
+	memoryStat, err := readCgroupKeyValues(cgroupPath, "memory.stat")
+	if err != nil {
+		return err
+	}
+	// The inactive page cache can be reclaimed, so don't count it as used.
+	stats.MemoryUsage = subtractUint(uint64(usage), memoryStat["total_inactive_file"])
+	limit, err := readCgroupInt(cgroupPath, "memory.limit_in_bytes")
+	if err != nil {
+		return err
+	}
+	stats.MemoryLimit = uint64(limit)
+
+	if cgroupPath, err = GetCgroupPath("blkio", c.id); err != nil {
+		return err
+	}
+	lines, err := readCgroupLines(cgroupPath, "blkio.throttle.io_service_bytes")
+	if err != nil {
+		return err
+	}
+	// Lines like "8:0 Read 4096", with a Total line.
+	for _, fields := range lines {
+		if len(fields) != 3 {
+			continue
+		}
+		value, _ := strconv.ParseUint(fields[2], 10, 64)
+		switch fields[1] {
+		case "Read":
+			stats.BlockRead += value
+		case "Write":
+			stats.BlockWrite += value
+		}
+	}
+	return nil
+}
+
+func (c *Cgroup) readUnifiedStats(stats *CgroupStats) error {
+	cgroupPath, err := GetUnifiedCgroupPath(c.id)
+	if err != nil {
+		return err
+	}
+	cpuStat, err := readCgroupKeyValues(cgroupPath, "cpu.stat")
+	if err != nil {
+		return err
+	}
+	stats.CpuUsage = cpuStat["usage_usec"] * 1000
+
+	usage, err := readCgroupInt(cgroupPath, "memory.current")
+	if err != nil {
+		return err
+	}
+	memoryStat, err := readCgroupKeyValues(cgroupPath, "memory.stat")
+	if err != nil {
+		return err
+	}
+	stats.MemoryUsage = subtractUint(uint64(usage), memoryStat["inactive_file"])
+	// memory.max is "max" when not set.
+	if limit, err := readCgroupInt(cgroupPath, "memory.max"); err == nil {
+		stats.MemoryLimit = uint64(limit)
+	}
+
+	lines, err := readCgroupLines(cgroupPath, "io.stat")
+	if err != nil {
+		return err
+	}
+	// Lines like "8:0 rbytes=4096 wbytes=0 rios=1 wios=0 ...".
+	for _, fields := range lines {
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			value, _ := strconv.ParseUint(kv[1], 10, 64)
+			switch kv[0] {
+			case "rbytes":
+				stats.BlockRead += value
+			case "wbytes":
+				stats.BlockWrite += value
+			}
+		}
+	}
+	return nil
+}
+
+// readCgroupLines reads a control file as the fields of its lines.
+func readCgroupLines(cgroupPath, name string) ([][]string, error) {
+	f, err := os.Open(path.Join(cgroupPath, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			lines = append(lines, fields)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// readCgroupKeyValues reads a control file of "key value" lines, like
+// memory.stat.
+func readCgroupKeyValues(cgroupPath, name string) (map[string]uint64, error) {
+	lines, err := readCgroupLines(cgroupPath, name)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]uint64)
+	for _, fields := range lines {
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad value of %v in %v: %w", fields[0], name, err)
+		}
+		values[fields[0]] = value
+	}
+	return values, nil
+}
+
+func subtractUint(a, b uint64) uint64 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type CgroupMode int
+
+const (
+	// Every subsystem has its own cgroup v1 hierarchy.
+	CgroupLegacy CgroupMode = iota
+	// The subsystems are on cgroup v1 hierarchies, with a cgroup v2
+	// hierarchy holding no controller mounted too.
+	CgroupHybrid
+	// All the subsystems are on the one cgroup v2 hierarchy.
+	CgroupUnified
+)
+
+// GetCgroupMode detects how cgroups are set up on the host. Only with
+// CgroupUnified do we use cgroup v2. A cgroup v1 hierarchy holding none of
+// our subsystems, like the name=systemd one, doesn't count.
+func GetCgroupMode() (CgroupMode, error) {
+	mounts, err := readMountInfo()
+	if err != nil {
+		return 0, err
+	}
+	hasV1, hasV2 := false, false
+	for _, m := range mounts {
+		switch m.fsType {
+		case "cgroup":
+			if holdsSubsystem(m) {
+				hasV1 = true
+			}
+		case "cgroup2":
+			hasV2 = true
+		}
+	}
+	switch {
+	case hasV1 && hasV2:
+		return CgroupHybrid, nil
+	case hasV1:
+		return CgroupLegacy, nil
+	case hasV2:
+		return CgroupUnified, nil
+	}
+	return 0, fmt.Errorf("no cgroup filesystem is mounted")
+}
+
+func holdsSubsystem(m mountInfo) bool {
+	for _, opt := range m.superOptions {
+		for _, subsys := range subsystems {
+			if opt == subsys.Name() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func FindUnifiedCgroupRoot() (string, error) {
+	mounts, err := readMountInfo()
+	if err != nil {
+		return "", err
+	}
+	for _, m := range mounts {
+		if m.fsType == "cgroup2" {
+			return m.mountPoint, nil
+		}
+	}
+	return "", fmt.Errorf("cgroup v2 is not mounted")
+}
+
+func GetUnifiedCgroupPath(id string) (string, error) {
+	root, err := FindUnifiedCgroupRoot()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(root, id), nil
+}
+
+// setUnified creates the cgroup directly under the root, after enabling for
+// the children of the root the controllers the subsystems need.
+func (c *Cgroup) setUnified(config SubsystemConfig) error {
+	root, err := FindUnifiedCgroupRoot()
+	if err != nil {
+		return err
+	}
+	if err := enableControllers(root); err != nil {
+		return err
+	}
+	cgroupPath := path.Join(root, c.id)
+	if err := os.MkdirAll(cgroupPath, 0755); err != nil {
+		return err
+	}
+
+	for _, subsys := range subsystems {
+		if err := subsys.SetUnified(cgroupPath, config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enableControllers enables the controllers of our subsystems which are
+// available; a limit of a missing controller fails to be set later.
+func enableControllers(root string) error {
+	data, err := ioutil.ReadFile(path.Join(root, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	available := make(map[string]bool)
+	for _, controller := range strings.Fields(string(data)) {
+		available[controller] = true
+	}
+	for _, subsys := range subsystems {
+		controller := subsys.Controller()
+		if controller == "" || !available[controller] {
+			continue
+		}
+		if err := writeCgroupFile(root, "cgroup.subtree_control", "+"+controller); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cgroup) applyUnified(pid int) error {
+	cgroupPath, err := GetUnifiedCgroupPath(c.id)
+	if err != nil {
+		return err
+	}
+	return writeCgroupFile(cgroupPath, "cgroup.procs", strconv.Itoa(pid))
+}
+
+func (c *Cgroup) destroyUnified() error {
+	cgroupPath, err := GetUnifiedCgroupPath(c.id)
+	if err != nil {
+		return err
+	}
+	// A cgroup is removed with rmdir even though it holds files.
+	if err := os.Remove(cgroupPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
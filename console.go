@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The messages sent by an attached client to the console server, each
+// prefixed with a byte of the type and four bytes of the length of the
+// payload. The server sends back the raw output of the container.
+const (
+	ConsoleInput  byte = 0
+	ConsoleResize byte = 1
+)
+
+const DefaultDetachKeys = "ctrl-p,ctrl-q"
+
+func makeContainerConsolePath(containerName string) string {
+	return fmt.Sprintf("%s/%s/console.sock", ContainersDir, containerName)
+}
+
+// ConsoleServer shares the pty of a container with the clients attached
+// through a unix socket: the output goes to all of them and the input of any
+// of them goes to the container.
+type ConsoleServer struct {
+	pty         *os.File
+	listener    net.Listener
+	mu          sync.Mutex
+	clients     map[net.Conn]bool
+	firstClient chan struct{}
+}
+
+func NewConsoleServer(containerName string, pty *os.File) (*ConsoleServer, error) {
+	socketPath := makeContainerConsolePath(containerName)
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	s := &ConsoleServer{
+		pty:         pty,
+		listener:    listener,
+		clients:     make(map[net.Conn]bool),
+		firstClient: make(chan struct{}),
+	}
+	go s.serve()
+	return s, nil
+}
+
+func (s *ConsoleServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if len(s.clients) == 0 {
+			select {
+			case <-s.firstClient:
+			default:
+				close(s.firstClient)
+			}
+		}
+		s.clients[conn] = true
+		s.mu.Unlock()
+		go s.serveClient(conn)
+	}
+}
+
+func (s *ConsoleServer) serveClient(conn net.Conn) {
+	defer s.removeClient(conn)
+	header := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return
+		}
+		switch header[0] {
+		case ConsoleInput:
+			if _, err := s.pty.Write(payload); err != nil {
+				return
+			}
+		case ConsoleResize:
+			if len(payload) != 4 {
+				return
+			}
+			ws := &Winsize{
+				Rows: binary.BigEndian.Uint16(payload[0:]),
+				Cols: binary.BigEndian.Uint16(payload[2:]),
+			}
+			setWinsize(s.pty.Fd(), ws)
+		}
+	}
+}
+
+func (s *ConsoleServer) removeClient(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, conn)
+	conn.Close()
+}
+
+// WaitClient waits until a client has attached or the timeout expires.
+func (s *ConsoleServer) WaitClient(timeout time.Duration) {
+	select {
+	case <-s.firstClient:
+	case <-time.After(timeout):
+	}
+}
+
+// Write sends the output of the container to all the clients. A client that
+// can't keep up is dropped rather than blocking the container.
+func (s *ConsoleServer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	var clients []net.Conn
+	for conn := range s.clients {
+		clients = append(clients, conn)
+	}
+	s.mu.Unlock()
+
+	for _, conn := range clients {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write(p); err != nil {
+			s.removeClient(conn)
+		}
+	}
+	return len(p), nil
+}
+
+// Close disconnects all the clients and removes the socket.
+func (s *ConsoleServer) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.clients {
+		conn.Close()
+	}
+	s.clients = make(map[net.Conn]bool)
+	return err
+}
+
+// ConsoleClient is the attached end of a ConsoleServer.
+type ConsoleClient struct {
+	conn net.Conn
+	mu   sync.Mutex
+}
+
+func DialConsole(containerName string) (*ConsoleClient, error) {
+	conn, err := net.Dial("unix", makeContainerConsolePath(containerName))
+	if err != nil {
+		return nil, err
+	}
+	return &ConsoleClient{conn: conn}, nil
+}
+
+func (c *ConsoleClient) send(msgType byte, payload []byte) error {
+	msg := make([]byte, 5+len(payload))
+	msg[0] = msgType
+	binary.BigEndian.PutUint32(msg[1:], uint32(len(payload)))
+	copy(msg[5:], payload)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+func (c *ConsoleClient) Write(p []byte) (int, error) {
+	if err := c.send(ConsoleInput, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *ConsoleClient) Resize(ws *Winsize) error {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload[0:], ws.Rows)
+	binary.BigEndian.PutUint16(payload[2:], ws.Cols)
+	return c.send(ConsoleResize, payload)
+}
+
+func (c *ConsoleClient) Read(p []byte) (int, error) {
+	return c.conn.Read(p)
+}
+
+func (c *ConsoleClient) Close() error {
+	return c.conn.Close()
+}
+
+// parseDetachKeys parses a comma separated key sequence like
+// `ctrl-p,ctrl-q`, where a key is either a single character or ctrl- with
+// one of a-z, @, [, \, ], ^ and _.
+func parseDetachKeys(s string) ([]byte, error) {
+	var keys []byte
+	for _, key := range strings.Split(s, ",") {
+		if len(key) == 1 {
+			keys = append(keys, key[0])
+			continue
+		}
+		if !strings.HasPrefix(key, "ctrl-") || len(key) != len("ctrl-")+1 {
+			return nil, fmt.Errorf("bad detach key `%v`", key)
+		}
+		c := key[len(key)-1]
+		switch {
+		case c >= 'a' && c <= 'z':
+			keys = append(keys, c-'a'+1)
+		case c == '@':
+			keys = append(keys, 0)
+		case c >= '[' && c <= '_':
+			keys = append(keys, c-'['+27)
+		default:
+			return nil, fmt.Errorf("bad detach key `%v`", key)
+		}
+	}
+	return keys, nil
+}
+
+// copyUntilDetach copies src to dst until EOF or the detach key sequence is
+// read, and reports whether it detached. The keys of a partially matched
+// sequence are held back until it's known not to be the sequence.
+func copyUntilDetach(dst io.Writer, src io.Reader, keys []byte) (bool, error) {
+	buf := make([]byte, 1024)
+	matched := 0
+	for {
+		n, err := src.Read(buf)
+		var out []byte
+		for _, b := range buf[0:n] {
+			if b == keys[matched] {
+				matched++
+				if matched == len(keys) {
+					if len(out) > 0 {
+						if _, err := dst.Write(out); err != nil {
+							return false, err
+						}
+					}
+					return true, nil
+				}
+				continue
+			}
+			if matched > 0 {
+				out = append(out, keys[0:matched]...)
+				matched = 0
+				if b == keys[0] {
+					matched = 1
+					continue
+				}
+			}
+			out = append(out, b)
+		}
+		if len(out) > 0 {
+			if _, err := dst.Write(out); err != nil {
+				return false, err
+			}
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
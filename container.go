@@ -4,6 +4,13 @@ import (
 	"math/rand"
     "time"
     "fmt"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
 )
 
 func makeContainerId() string {
@@ -17,11 +24,39 @@ func makeContainerId() string {
 	return string(b)
 }
 
+func makeShortId(containerId string) string {
+	if len(containerId) > 12 {
+		return containerId[0:12]
+	}
+	return containerId
+}
+
 const (
 	ContainersDir string = "/var/run/mydocker/containers"
 	ImageDir      string = "/var/run/mydocker/images"
 )
 
+// A container name becomes a directory under ContainersDir, so it must be a
+// single path component; starting with an alphanumeric rules out `.` and `..`.
+var containerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// The longest container name, which keeps the path of the console socket in
+// the container directory within the 108 bytes of a unix socket address.
+const MaxContainerNameLength = 64
+
+func validateContainerName(name string) error {
+	if name == "" {
+		return fmt.Errorf("missing container name")
+	}
+	if !containerNamePattern.MatchString(name) {
+		return fmt.Errorf("bad container name `%v`", name)
+	}
+	if len(name) > MaxContainerNameLength {
+		return fmt.Errorf("the container name `%v` is longer than %d characters", name, MaxContainerNameLength)
+	}
+	return nil
+}
+
 func makeContainerDir(containerName string) string {
 	return fmt.Sprintf("%s/%s", ContainersDir, containerName)
 }
@@ -41,6 +76,229 @@ func makeContainerWorkDir(containerName string) string {
 	return path
 }
 
+func makeContainerConfigPath(containerName string) string {
+	return fmt.Sprintf("%s/%s/config.json", ContainersDir, containerName)
+}
+
+func makeContainerLogPath(containerName string) string {
+	return fmt.Sprintf("%s/%s/json.log", ContainersDir, containerName)
+}
+
 func makeImagePath(imageName string) string {
 	return fmt.Sprintf("%s/%s", ImageDir, imageName)
 }
+
+const (
+	StatusCreated string = "created"
+	StatusRunning string = "running"
+	StatusExited  string = "exited"
+	// Running with all the processes frozen.
+	StatusPaused string = "paused"
+)
+
+type Volume struct {
+	Source      string
+	Destination string
+	// Whether Source was created by mydocker for the container.
+	Created bool
+}
+
+// ContainerInfo is the persistent state of a container, stored as json in
+// the container's directory.
+type ContainerInfo struct {
+	Id      string
+	Name    string
+	Image   string
+	Command []string
+	Pid     int
+	// When the process started, in clock ticks after boot, which tells it
+	// from a later process reusing its pid.
+	PidStartTime uint64
+	// The process creating the container, which waits for it then.
+	CreatorPid       int
+	CreatorStartTime uint64
+	Status           string
+	CreatedAt        time.Time
+	StartedAt        time.Time
+	FinishedAt       time.Time
+	ExitCode         int
+	NetworkMode      string
+	// The bridge network the container is connected to, if any.
+	Network  string
+	IP       string
+	Ports    []PortMapping
+	CgroupId string
+	// The resources set on the cgroup.
+	Resources SubsystemConfig
+	Volumes   []Volume
+	// Whether the container has a terminal, served on its console socket.
+	Tty bool
+}
+
+func NewContainerInfo(name string) (*ContainerInfo, error) {
+	jsonStr, err := ioutil.ReadFile(makeContainerConfigPath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ContainerInfo{}
+	if err := json.Unmarshal(jsonStr, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (c *ContainerInfo) Save() error {
+	if err := os.MkdirAll(makeContainerDir(c.Name), 0755); err != nil {
+		return err
+	}
+	jsonStr, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that readers never see a partially
+	// written state.
+	configPath := makeContainerConfigPath(c.Name)
+	tmpPath := configPath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, jsonStr, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, configPath)
+}
+
+// IsRunning checks the recorded status against the process table, so that a
+// container whose process died without anyone updating the state is not
+// reported as running. A paused container is running too.
+func (c *ContainerInfo) IsRunning() bool {
+	return (c.Status == StatusRunning || c.Status == StatusPaused) && c.ProcessExists()
+}
+
+// ProcessExists tells whether the process of the container is still there,
+// rather than gone with its pid maybe reused.
+func (c *ContainerInfo) ProcessExists() bool {
+	if c.Pid <= 0 {
+		return false
+	}
+	startTime, err := processStartTime(c.Pid)
+	return err == nil && startTime == c.PidStartTime
+}
+
+// CreatorExists tells whether the process creating the container is still
+// there. A container left created by a dead creator never starts.
+func (c *ContainerInfo) CreatorExists() bool {
+	if c.CreatorPid <= 0 {
+		return false
+	}
+	startTime, err := processStartTime(c.CreatorPid)
+	return err == nil && startTime == c.CreatorStartTime
+}
+
+// Signal sends the signal to the process of the container, unless it's gone.
+func (c *ContainerInfo) Signal(sig syscall.Signal) error {
+	if !c.ProcessExists() {
+		return syscall.ESRCH
+	}
+	return syscall.Kill(c.Pid, sig)
+}
+
+// WaitExit polls until the process of the container is gone or the timeout
+// expires, and reports whether it's gone.
+func (c *ContainerInfo) WaitExit(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for c.ProcessExists() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
+
+func ListContainers() ([]ContainerInfo, error) {
+	entries, err := ioutil.ReadDir(ContainersDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var containers []ContainerInfo
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := NewContainerInfo(entry.Name())
+		if err != nil {
+			// Not a container directory or its state is gone.
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		containers = append(containers, *info)
+	}
+	return containers, nil
+}
+
+// FindContainer resolves a container by name, full id or unique id prefix.
+func FindContainer(nameOrId string) (*ContainerInfo, error) {
+	if err := validateContainerName(nameOrId); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(makeContainerConfigPath(nameOrId)); err == nil {
+		return NewContainerInfo(nameOrId)
+	}
+
+	containers, err := ListContainers()
+	if err != nil {
+		return nil, err
+	}
+	var found *ContainerInfo
+	for i := range containers {
+		if !strings.HasPrefix(containers[i].Id, nameOrId) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("the container id prefix `%v` is ambiguous", nameOrId)
+		}
+		found = &containers[i]
+	}
+	if found == nil {
+		return nil, fmt.Errorf("the container `%v` does not exist", nameOrId)
+	}
+	return found, nil
+}
+
+// lockContainer takes an exclusive lock on the container directory, which
+// serializes the state changes made by different mydocker processes. Closing
+// the returned file releases the lock.
+func lockContainer(name string) (*os.File, error) {
+	dir, err := os.Open(makeContainerDir(name))
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(dir.Fd()), syscall.LOCK_EX); err != nil {
+		dir.Close()
+		return nil, err
+	}
+	return dir, nil
+}
+
+// processStartTime reads the start time of the process, the 22nd field of
+// its stat file.
+func processStartTime(pid int) (uint64, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// The fields after the command name, which may hold spaces, start with
+	// the 3rd one.
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("bad stat of process %d", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
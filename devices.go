@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// Wildcard matches any major or minor number in a device rule.
+const Wildcard = -1
+
+// Device is a rule of the devices cgroup and, when it has a path, a device
+// node created in the container.
+type Device struct {
+	// c for a character device, b for a block device, or a for both in a
+	// rule.
+	Type  string
+	Major int64
+	Minor int64
+	// A combination of r, w and m, for read, write and mknod.
+	Permissions string
+	// The path of the node in the container, or empty for a rule only.
+	Path     string
+	FileMode os.FileMode
+	Uid      uint32
+	Gid      uint32
+}
+
+func (d Device) String() string {
+	number := func(n int64) string {
+		if n == Wildcard {
+			return "*"
+		}
+		return fmt.Sprint(n)
+	}
+	return fmt.Sprintf("%s %s:%s %s", d.Type, number(d.Major), number(d.Minor), d.Permissions)
+}
+
+// The device nodes every container gets.
+var defaultDevices = []Device{
+	{Type: "c", Major: 1, Minor: 3, Permissions: "rwm", Path: "/dev/null", FileMode: 0666},
+	{Type: "c", Major: 1, Minor: 5, Permissions: "rwm", Path: "/dev/zero", FileMode: 0666},
+	{Type: "c", Major: 1, Minor: 7, Permissions: "rwm", Path: "/dev/full", FileMode: 0666},
+	{Type: "c", Major: 1, Minor: 8, Permissions: "rwm", Path: "/dev/random", FileMode: 0666},
+	{Type: "c", Major: 1, Minor: 9, Permissions: "rwm", Path: "/dev/urandom", FileMode: 0666},
+	{Type: "c", Major: 5, Minor: 0, Permissions: "rwm", Path: "/dev/tty", FileMode: 0666},
+}
+
+// The rules allowing what is not a node of defaultDevices. Any node may be
+// created, but it can only be used when allowed by another rule.
+var defaultDeviceRules = []Device{
+	{Type: "c", Major: Wildcard, Minor: Wildcard, Permissions: "m"},
+	{Type: "b", Major: Wildcard, Minor: Wildcard, Permissions: "m"},
+	// /dev/pts/ptmx, and the terminals of /dev/pts.
+	{Type: "c", Major: 5, Minor: 2, Permissions: "rwm"},
+	{Type: "c", Major: 136, Minor: Wildcard, Permissions: "rwm"},
+}
+
+// parseDevice parses a --device option like
+// HOST[:CONTAINER][:PERMISSIONS], e.g. /dev/sdc:/dev/xvdc:rw.
+func parseDevice(spec string) (Device, error) {
+	parts := strings.Split(spec, ":")
+	hostPath, containerPath, permissions := parts[0], parts[0], "rwm"
+	switch len(parts) {
+	case 1:
+	case 2:
+		if isDevicePermissions(parts[1]) {
+			permissions = parts[1]
+		} else {
+			containerPath = parts[1]
+		}
+	case 3:
+		containerPath, permissions = parts[1], parts[2]
+	default:
+		return Device{}, fmt.Errorf("bad format `%v`, expected HOST[:CONTAINER][:PERMISSIONS]", spec)
+	}
+	if !isDevicePermissions(permissions) {
+		return Device{}, fmt.Errorf("bad permissions `%v`, expected a combination of r, w and m", permissions)
+	}
+	if !path.IsAbs(containerPath) {
+		return Device{}, fmt.Errorf("the container path `%v` must be absolute", containerPath)
+	}
+
+	fileInfo, err := os.Stat(hostPath)
+	if err != nil {
+		return Device{}, err
+	}
+	device := Device{
+		Permissions: permissions,
+		Path:        path.Clean(containerPath),
+		FileMode:    fileInfo.Mode().Perm(),
+	}
+	switch {
+	case fileInfo.Mode()&os.ModeCharDevice != 0:
+		device.Type = "c"
+	case fileInfo.Mode()&os.ModeDevice != 0:
+		device.Type = "b"
+	default:
+		return Device{}, fmt.Errorf("%v is not a device", hostPath)
+	}
+	stat := fileInfo.Sys().(*syscall.Stat_t)
+	device.Major, device.Minor = splitDeviceNumber(stat.Rdev)
+	device.Uid, device.Gid = stat.Uid, stat.Gid
+	return device, nil
+}
+
+func isDevicePermissions(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("rwm", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// makeDeviceNumber encodes a dev_t as glibc's gnu_dev_makedev does.
+func makeDeviceNumber(major, minor int64) int {
+	return int(minor&0xff | (major&0xfff)<<8 | (minor&^0xff)<<12 | (major&^0xfff)<<32)
+}
+
+// createDeviceNode creates the node of the device, replacing what the
+// image may have at its path.
+func createDeviceNode(device Device) error {
+	if err := os.MkdirAll(path.Dir(device.Path), 0755); err != nil {
+		return err
+	}
+	mode := uint32(syscall.S_IFCHR)
+	if device.Type == "b" {
+		mode = syscall.S_IFBLK
+	}
+	if err := os.Remove(device.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := syscall.Mknod(device.Path, mode|uint32(device.FileMode), makeDeviceNumber(device.Major, device.Minor)); err != nil {
+		return fmt.Errorf("can't create device %v: %w", device.Path, err)
+	}
+	// The mode was masked by the umask.
+	if err := os.Chmod(device.Path, device.FileMode); err != nil {
+		return err
+	}
+	return os.Chown(device.Path, int(device.Uid), int(device.Gid))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"runtime"
+	"unsafe"
+)
+
+// With cgroup v2, the devices are allowed by an eBPF program attached to
+// the cgroup instead of a controller. The program is run with a
+// bpf_cgroup_dev_ctx on every access to a device, and allows it by
+// returning 1.
+
+// bpfInsn is an eBPF instruction as struct bpf_insn.
+type bpfInsn struct {
+	code uint8
+	// The destination register in the low nibble and the source one in the
+	// high nibble.
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+const (
+	bpfLdxMemW   = 0x61 // BPF_LDX | BPF_MEM | BPF_W
+	bpfAlu32AndK = 0x54 // BPF_ALU | BPF_AND | BPF_K
+	bpfAlu32RshK = 0x74 // BPF_ALU | BPF_RSH | BPF_K
+	bpfAlu32MovX = 0xbc // BPF_ALU | BPF_MOV | BPF_X
+	bpfAlu64MovK = 0xb7 // BPF_ALU64 | BPF_MOV | BPF_K
+	bpfJmpJneK   = 0x55 // BPF_JMP | BPF_JNE | BPF_K
+	bpfJmpExit   = 0x95 // BPF_JMP | BPF_EXIT
+)
+
+// deviceFilterProgram compiles the rules into a program which allows an
+// access when any rule matches it.
+func deviceFilterProgram(rules []Device) []bpfInsn {
+	// r2 = type, r3 = access, r4 = major, r5 = minor, from the context
+	// in r1.
+	insns := []bpfInsn{
+		{code: bpfLdxMemW, regs: 2 | 1<<4, off: 0},
+		{code: bpfAlu32AndK, regs: 2, imm: 0xffff},
+		{code: bpfLdxMemW, regs: 3 | 1<<4, off: 0},
+		{code: bpfAlu32RshK, regs: 3, imm: 16},
+		{code: bpfLdxMemW, regs: 4 | 1<<4, off: 4},
+		{code: bpfLdxMemW, regs: 5 | 1<<4, off: 8},
+	}
+	for _, rule := range rules {
+		var block []bpfInsn
+		switch rule.Type {
+		case "c":
+			block = append(block, bpfInsn{code: bpfJmpJneK, regs: 2, imm: unix.BPF_DEVCG_DEV_CHAR})
+		case "b":
+			block = append(block, bpfInsn{code: bpfJmpJneK, regs: 2, imm: unix.BPF_DEVCG_DEV_BLOCK})
+		}
+		if denied := ^devicePermissionsMask(rule.Permissions) & 7; denied != 0 {
+			block = append(block,
+				bpfInsn{code: bpfAlu32MovX, regs: 1 | 3<<4},
+				bpfInsn{code: bpfAlu32AndK, regs: 1, imm: denied},
+				bpfInsn{code: bpfJmpJneK, regs: 1, imm: 0})
+		}
+		if rule.Major != Wildcard {
+			block = append(block, bpfInsn{code: bpfJmpJneK, regs: 4, imm: int32(rule.Major)})
+		}
+		if rule.Minor != Wildcard {
+			block = append(block, bpfInsn{code: bpfJmpJneK, regs: 5, imm: int32(rule.Minor)})
+		}
+		block = append(block,
+			bpfInsn{code: bpfAlu64MovK, regs: 0, imm: 1},
+			bpfInsn{code: bpfJmpExit})
+		// A mismatch jumps to the next rule.
+		for i := range block {
+			if block[i].code == bpfJmpJneK {
+				block[i].off = int16(len(block) - i - 1)
+			}
+		}
+		insns = append(insns, block...)
+	}
+	return append(insns,
+		bpfInsn{code: bpfAlu64MovK, regs: 0, imm: 0},
+		bpfInsn{code: bpfJmpExit})
+}
+
+func devicePermissionsMask(permissions string) int32 {
+	var mask int32
+	for _, c := range permissions {
+		switch c {
+		case 'r':
+			mask |= unix.BPF_DEVCG_ACC_READ
+		case 'w':
+			mask |= unix.BPF_DEVCG_ACC_WRITE
+		case 'm':
+			mask |= unix.BPF_DEVCG_ACC_MKNOD
+		}
+	}
+	return mask
+}
+
+// The leading fields of union bpf_attr for BPF_PROG_LOAD.
+type bpfProgLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+}
+
+// The fields of union bpf_attr for BPF_PROG_ATTACH.
+type bpfProgAttachAttr struct {
+	targetFd    uint32
+	attachBpfFd uint32
+	attachType  uint32
+	attachFlags uint32
+}
+
+// attachDeviceFilter allows only the devices of the rules in the cgroup,
+// replacing the program attached before if any.
+func attachDeviceFilter(cgroupPath string, rules []Device) error {
+	insns := deviceFilterProgram(rules)
+	license := []byte("Apache\x00")
+	loadAttr := bpfProgLoadAttr{
+		progType: unix.BPF_PROG_TYPE_CGROUP_DEVICE,
+		insnCnt:  uint32(len(insns)),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+	}
+	progFd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD, uintptr(unsafe.Pointer(&loadAttr)), unsafe.Sizeof(loadAttr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if errno != 0 {
+		return fmt.Errorf("can't load the device filter: %w", errno)
+	}
+	// The cgroup holds the program once attached.
+	defer unix.Close(int(progFd))
+
+	dir, err := os.Open(cgroupPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	attachAttr := bpfProgAttachAttr{
+		targetFd:    uint32(dir.Fd()),
+		attachBpfFd: uint32(progFd),
+		attachType:  unix.BPF_CGROUP_DEVICE,
+	}
+	_, _, errno = unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_ATTACH, uintptr(unsafe.Pointer(&attachAttr)), unsafe.Sizeof(attachAttr))
+	if errno != 0 {
+		return fmt.Errorf("can't attach the device filter: %w", errno)
+	}
+	return nil
+}
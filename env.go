@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const DefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// makeContainerEnv builds the environment of a container from the env files
+// and the `-e` options in order, later settings overriding earlier ones. A
+// variable given without a value takes its value from the host, and is left
+// out if it's not set there. Variables with ReservedEnvPrefix are rejected.
+func makeContainerEnv(envFiles []string, envs []string) ([]string, error) {
+	settings := []string{"PATH=" + DefaultPath}
+	for _, envFile := range envFiles {
+		fileEnvs, err := readEnvFile(envFile)
+		if err != nil {
+			return nil, err
+		}
+		settings = append(settings, fileEnvs...)
+	}
+	settings = append(settings, envs...)
+
+	var env []string
+	index := make(map[string]int)
+	for _, kv := range settings {
+		key := kv
+		if i := strings.Index(kv, "="); i >= 0 {
+			key = kv[0:i]
+		} else if value, exist := os.LookupEnv(kv); exist {
+			kv = kv + "=" + value
+		} else {
+			continue
+		}
+		if key == "" {
+			return nil, fmt.Errorf("bad environment variable `%v`", kv)
+		}
+		if strings.HasPrefix(key, ReservedEnvPrefix) {
+			return nil, fmt.Errorf("the environment variable `%v` is reserved", key)
+		}
+		if i, exist := index[key]; exist {
+			env[i] = kv
+			continue
+		}
+		index[key] = len(env)
+		env = append(env, kv)
+	}
+	return env, nil
+}
+
+// readEnvFile reads KEY=VALUE lines, skipping empty lines and comments.
+func readEnvFile(envFile string) ([]string, error) {
+	file, err := os.Open(envFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var envs []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		envs = append(envs, line)
+	}
+	return envs, scanner.Err()
+}
+
+func lookupEnv(env []string, key string) (string, bool) {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			return kv[len(key)+1:], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// The files generated for each container in its directory and bind-mounted
+// into its /etc.
+var etcFiles = []string{"hostname", "hosts", "resolv.conf"}
+
+var defaultNameservers = []string{"8.8.8.8", "8.8.4.4"}
+
+func makeContainerEtcFilePath(containerName, file string) string {
+	return path.Join(makeContainerDir(containerName), file)
+}
+
+// mountEtcFiles generates the files in etcFiles and bind-mounts them into the
+// rootfs. The hosts file is written without the container's address, which is
+// known only once the container is connected; see writeHostsFile.
+func mountEtcFiles(opts RunOptions) error {
+	if err := ioutil.WriteFile(makeContainerEtcFilePath(opts.containerName, "hostname"), []byte(opts.hostname+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := writeHostsFile(opts, nil); err != nil {
+		return err
+	}
+	if err := writeResolvConf(opts); err != nil {
+		return err
+	}
+
+	etcDir := path.Join(makeContainerMergedDir(opts.containerName), "etc")
+	if err := os.MkdirAll(etcDir, 0755); err != nil {
+		return err
+	}
+	for _, file := range etcFiles {
+		target := path.Join(etcDir, file)
+		// The target may be a dangling symlink, e.g. resolv.conf managed by
+		// systemd-resolved, so replace it with a regular file.
+		if fi, err := os.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		f.Close()
+		if err := syscall.Mount(makeContainerEtcFilePath(opts.containerName, file), target, "bind", syscall.MS_BIND, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unmountEtcFiles(containerName string) error {
+	for _, file := range etcFiles {
+		if err := unmount(path.Join(makeContainerMergedDir(containerName), "etc", file)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeHostsFile rewrites the hosts file of the container in place, so that
+// the change is seen through the bind mount.
+func writeHostsFile(opts RunOptions, ip net.IP) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "127.0.0.1\tlocalhost\n")
+	fmt.Fprintf(&buf, "::1\tlocalhost ip6-localhost ip6-loopback\n")
+	if ip != nil {
+		fmt.Fprintf(&buf, "%s\t%s\n", ip, opts.hostname)
+	} else {
+		fmt.Fprintf(&buf, "127.0.1.1\t%s\n", opts.hostname)
+	}
+	for _, extraHost := range opts.extraHosts {
+		kv := strings.SplitN(extraHost, ":", 2)
+		fmt.Fprintf(&buf, "%s\t%s\n", kv[1], kv[0])
+	}
+	return ioutil.WriteFile(makeContainerEtcFilePath(opts.containerName, "hosts"), buf.Bytes(), 0644)
+}
+
+// writeResolvConf uses the name servers and search domains given by the
+// options, falling back on those of the host. Name servers on the loopback of
+// the host, such as the stub resolver of systemd-resolved, can't be reached
+// from a container with its own network.
+func writeResolvConf(opts RunOptions) error {
+	nameservers, searches, options, err := readHostResolvConf()
+	if err != nil {
+		return err
+	}
+	if len(opts.dns) > 0 {
+		nameservers = opts.dns
+	} else if opts.network.Type != NetworkHost {
+		var reachable []string
+		for _, ns := range nameservers {
+			if ip := net.ParseIP(ns); ip != nil && !ip.IsLoopback() {
+				reachable = append(reachable, ns)
+			}
+		}
+		nameservers = reachable
+	}
+	if len(nameservers) == 0 {
+		nameservers = defaultNameservers
+	}
+	if len(opts.dnsSearch) > 0 {
+		searches = opts.dnsSearch
+	}
+
+	var buf bytes.Buffer
+	for _, ns := range nameservers {
+		fmt.Fprintf(&buf, "nameserver %s\n", ns)
+	}
+	if len(searches) > 0 {
+		fmt.Fprintf(&buf, "search %s\n", strings.Join(searches, " "))
+	}
+	if len(options) > 0 {
+		fmt.Fprintf(&buf, "options %s\n", strings.Join(options, " "))
+	}
+	return ioutil.WriteFile(makeContainerEtcFilePath(opts.containerName, "resolv.conf"), buf.Bytes(), 0644)
+}
+
+func readHostResolvConf() (nameservers, searches, options []string, err error) {
+	file, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			nameservers = append(nameservers, fields[1])
+		case "search", "domain":
+			searches = fields[1:]
+		case "options":
+			options = append(options, fields[1:]...)
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// parseExtraHost checks an --add-host option of the form HOST:IP.
+func parseExtraHost(extraHost string) error {
+	kv := strings.SplitN(extraHost, ":", 2)
+	if len(kv) != 2 || kv[0] == "" || net.ParseIP(kv[1]) == nil {
+		return fmt.Errorf("bad --add-host `%v`; expecting HOST:IP", extraHost)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/urfave/cli"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+var execCommand = cli.Command{
+	Name:      "exec",
+	Usage:     "run a command in a running container",
+	UsageText: `mydocker exec [OPTIONS] CONTAINER COMMAND [ARG...]`,
+	// Don't take the options of the command for ours.
+	SkipArgReorder: true,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "i",
+			Usage: "keep STDIN open",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 2 {
+			return fmt.Errorf("missing container or command")
+		}
+
+		info, err := FindContainer(ctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		if !info.IsRunning() {
+			return fmt.Errorf("the container `%v` is not running", ctx.Args().Get(0))
+		}
+		if info.Status == StatusPaused {
+			return fmt.Errorf("the container `%v` is paused", ctx.Args().Get(0))
+		}
+		env, err := readProcessEnviron(info.Pid)
+		if err != nil {
+			return err
+		}
+		cwd, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", info.Pid))
+		if err != nil {
+			return err
+		}
+		// The command joins the cgroups when it's forked by
+		// enter_namespaces, since our threads would count in them.
+		cgroupProcs, err := NewCgroup(info.CgroupId).ProcsPaths()
+		if err != nil {
+			return err
+		}
+
+		self, err := os.Readlink("/proc/self/exe")
+		if err != nil {
+			return err
+		}
+		// Re-executed, we never get to run: enter_namespaces in nsenter.go
+		// execs the command in the container.
+		cmd := exec.Command(self, append([]string{"exec", info.Name}, ctx.Args()[1:]...)...)
+		cmd.Env = append(env, ExecPidEnv+"="+strconv.Itoa(info.Pid), ExecCwdEnv+"="+cwd,
+			ExecCgroupsEnv+"="+strings.Join(cgroupProcs, ":"))
+		if ctx.Bool("i") {
+			cmd.Stdin = os.Stdin
+		}
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			if _, ok := err.(*exec.ExitError); ok {
+				return cli.NewExitError("", exitStatus(cmd.ProcessState))
+			}
+			return err
+		}
+		return nil
+	},
+}
+
+func readProcessEnviron(pid int) ([]string, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/environ", pid))
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	for _, kv := range bytes.Split(data, []byte{0}) {
+		// The process may have rewritten its environment.
+		if len(kv) > 0 && !bytes.HasPrefix(kv, []byte(ReservedEnvPrefix)) {
+			env = append(env, string(kv))
+		}
+	}
+	return env, nil
+}
@@ -7,30 +7,84 @@ import (
 	"syscall"
     "os"
     "io/ioutil"
-    "strings"
     "fmt"
+    "encoding/json"
+    "strings"
+    "os/signal"
+    "path"
 )
 
 var initCommand = cli.Command{
 	Name:  "init",
 	Usage: "Not intended for external use",
 	Action: func(ctx *cli.Context) error {
-		log.Printf("enter initCommand")
-		cmdArray, err := readCommand()
+		msg, err := readInitMessage()
 		if err != nil {
 			return err
 		}
 
-		if err := setUpMountPoints(); err != nil {
+		if err := setUpMountPoints(msg.Devices); err != nil {
 			return err
 		}
+		if msg.Hostname != "" {
+			if err := syscall.Sethostname([]byte(msg.Hostname)); err != nil {
+				return err
+			}
+		}
+		if msg.SetUpLoopback {
+			if err := setInterfaceUp("lo"); err != nil {
+				return err
+			}
+		}
+		if msg.Cwd != "" {
+			if err := os.MkdirAll(msg.Cwd, 0755); err != nil {
+				return err
+			}
+			if err := os.Chdir(msg.Cwd); err != nil {
+				return err
+			}
+		}
 
-		path, err := exec.LookPath(cmdArray[0])
+		var user *ExecUser
+		if msg.User != "" {
+			if user, err = lookupUser(msg.User); err != nil {
+				return err
+			}
+			if _, exist := lookupEnv(msg.Env, "HOME"); !exist {
+				msg.Env = append(msg.Env, "HOME="+user.Home)
+			}
+		}
+
+		// Replace our environment with the container's so that the
+		// command is looked up in the container's PATH.
+		if _, exist := lookupEnv(msg.Env, "HOSTNAME"); !exist {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return err
+			}
+			msg.Env = append(msg.Env, "HOSTNAME="+hostname)
+		}
+		os.Clearenv()
+		for _, kv := range msg.Env {
+			kv := strings.SplitN(kv, "=", 2)
+			if err := os.Setenv(kv[0], kv[1]); err != nil {
+				return err
+			}
+		}
+
+		path, err := exec.LookPath(msg.Args[0])
 		if err != nil {
 			return err
 		}
-		log.Printf("command path=%v", path)
-		if err := syscall.Exec(path, cmdArray, os.Environ()); err != nil {
+		if msg.Init {
+			return runAsPid1(path, msg.Args, msg.Env, user)
+		}
+		if user != nil {
+			if err := switchUser(user); err != nil {
+				return err
+			}
+		}
+		if err := syscall.Exec(path, msg.Args, msg.Env); err != nil {
 			log.Printf("can't exec: %v", err)
 			return err
 		}
@@ -39,28 +93,59 @@ var initCommand = cli.Command{
 	},
 }
 
-func readCommand() ([]string, error) {
+const InitMessageVersion = 3
+
+// InitMessage is sent by `mydocker run` to `mydocker init` as json through
+// the pipe, telling it how to start the process of the container.
+type InitMessage struct {
+	Version int
+	Args    []string
+	Env     []string
+	// The working directory, or empty for the root.
+	Cwd      string
+	Hostname string
+	// USER[:GROUP] to run as, or empty for root.
+	User string
+	// Whether to stay as PID 1 instead of exec'ing the command.
+	Init bool
+	// The device nodes to create in /dev.
+	Devices []Device
+	// Whether to bring up the loopback, in a network namespace of its own.
+	SetUpLoopback bool
+}
+
+func readInitMessage() (*InitMessage, error) {
 	const ReadPipe = uintptr(3)
 	pipe := os.NewFile(ReadPipe, "pipe")
 	defer pipe.Close()
-	msg, err := ioutil.ReadAll(pipe)
+	data, err := ioutil.ReadAll(pipe)
 	if err != nil {
 		return nil, err
 	}
-	s := string(msg)
-	cmdArray := strings.Split(s, " ")
-	if len(cmdArray) == 0 {
+	msg := &InitMessage{}
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("bad init message: %w", err)
+	}
+	if msg.Version != InitMessageVersion {
+		return nil, fmt.Errorf("unsupported init message version %v; expecting %v", msg.Version, InitMessageVersion)
+	}
+	if len(msg.Args) == 0 {
 		return nil, fmt.Errorf("empty command")
 	}
-	return cmdArray, nil
+	return msg, nil
 }
 
-func setUpMountPoints() error {
+func setUpMountPoints(devices []Device) error {
 	cwd, err := os.Getwd()
 	if err != nil {
 		return err
 	}
 
+	// /dev goes before pivoting, while the terminal from the devpts of the
+	// host can still be bind mounted.
+	if err := setUpDev(cwd, devices); err != nil {
+		return err
+	}
 	if err := pivotRoot(cwd); err != nil {
 		return err
 	}
@@ -68,10 +153,83 @@ func setUpMountPoints() error {
 		log.Printf("can't mount proc: %v", err)
 		return err
 	}
-	if err := syscall.Mount("devtmpfs", "/dev", "devtmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755"); err != nil {
+	return nil
+}
+
+// setUpDev mounts a tmpfs on /dev of the root holding only the nodes of the
+// devices, rather than the host's devtmpfs holding all of them.
+func setUpDev(root string, devices []Device) error {
+	dev := path.Join(root, "dev")
+	if err := os.MkdirAll(dev, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", dev, "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755,size=65536k"); err != nil {
 		log.Printf("can't mount tmpfs: %v", err)
 		return err
 	}
+	for _, device := range devices {
+		device.Path = path.Join(root, device.Path)
+		if err := createDeviceNode(device); err != nil {
+			return err
+		}
+	}
+
+	// A devpts of our own, so that the terminals of the host are not seen.
+	if err := os.MkdirAll(path.Join(dev, "pts"), 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("devpts", path.Join(dev, "pts"), "devpts", syscall.MS_NOSUID|syscall.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=0620,gid=5"); err != nil {
+		log.Printf("can't mount devpts: %v", err)
+		return err
+	}
+	// The terminal of a container with -t is from the devpts of the host,
+	// which is not seen in the container, so it's made the console to be
+	// found by ttyname(3).
+	if isTerminal(0) {
+		if err := setUpConsole(path.Join(dev, "console")); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(path.Join(dev, "shm"), 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("shm", path.Join(dev, "shm"), "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "mode=1777,size=65536k"); err != nil {
+		log.Printf("can't mount shm: %v", err)
+		return err
+	}
+
+	links := [][2]string{
+		{"pts/ptmx", "ptmx"},
+		{"/proc/self/fd", "fd"},
+		{"/proc/self/fd/0", "stdin"},
+		{"/proc/self/fd/1", "stdout"},
+		{"/proc/self/fd/2", "stderr"},
+	}
+	for _, link := range links {
+		if err := os.Symlink(link[0], path.Join(dev, link[1])); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// setUpConsole bind mounts the terminal on stdin onto the console. The
+// terminal is mounted by its path, since stdin was opened in the mount
+// namespace of the host, not in our copy of it.
+func setUpConsole(console string) error {
+	terminal, err := os.Readlink("/proc/self/fd/0")
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(console, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	file.Close()
+	if err := syscall.Mount(terminal, console, "", syscall.MS_BIND, ""); err != nil {
+		log.Printf("can't mount console: %v", err)
+		return err
+	}
 	return nil
 }
 
@@ -95,3 +253,66 @@ func pivotRoot(path string) error {
 	}
 	return os.Remove(oldRootFilename)
 }
+
+// runAsPid1 runs the command as a child and stays as PID 1 of the container,
+// forwarding signals to the child and reaping the orphans which are
+// reparented to us. It exits with the exit status of the child.
+func runAsPid1(path string, args []string, env []string, user *ExecUser) error {
+	sigs := make(chan os.Signal, 64)
+	signal.Notify(sigs)
+
+	cmd := exec.Command(path)
+	cmd.Args = args
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// Keep the controlling terminal and put the child in the foreground,
+	// since stealing the terminal would need privileges the child may not
+	// have.
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	if isTerminal(os.Stdin.Fd()) {
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = int(os.Stdin.Fd())
+	}
+	if user != nil {
+		credential := &syscall.Credential{
+			Uid: uint32(user.Uid),
+			Gid: uint32(user.Gid),
+		}
+		for _, gid := range user.Groups {
+			credential.Groups = append(credential.Groups, uint32(gid))
+		}
+		cmd.SysProcAttr.Credential = credential
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	for sig := range sigs {
+		switch sig {
+		case syscall.SIGCHLD:
+			for {
+				var status syscall.WaitStatus
+				pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+				if err != nil || pid <= 0 {
+					break
+				}
+				if pid != cmd.Process.Pid {
+					continue
+				}
+				if status.Signaled() {
+					os.Exit(128 + int(status.Signal()))
+				}
+				os.Exit(status.ExitStatus())
+			}
+		case syscall.SIGURG:
+			// Used by the Go runtime to preempt goroutines.
+		default:
+			if err := syscall.Kill(cmd.Process.Pid, sig.(syscall.Signal)); err != nil && err != syscall.ESRCH {
+				log.Printf("can't forward signal %v: %v", sig, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli"
+	"os"
+)
+
+var inspectCommand = cli.Command{
+	Name:      "inspect",
+	Usage:     "display detailed information on one or more containers",
+	UsageText: `mydocker inspect CONTAINER...`,
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) == 0 {
+			return fmt.Errorf("missing container name")
+		}
+		var inspects []ContainerInspect
+		for _, name := range ctx.Args() {
+			inspect, err := inspectContainer(name)
+			if err != nil {
+				return err
+			}
+			inspects = append(inspects, *inspect)
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "    ")
+		return encoder.Encode(inspects)
+	},
+}
+
+// ContainerInspect is the state of a container along with what is read
+// live from the host.
+type ContainerInspect struct {
+	ContainerInfo
+	// The number of processes in the container.
+	Pids int64
+}
+
+func inspectContainer(nameOrId string) (*ContainerInspect, error) {
+	info, err := FindContainer(nameOrId)
+	if err != nil {
+		return nil, err
+	}
+	inspect := &ContainerInspect{ContainerInfo: *info}
+	if (info.Status == StatusRunning || info.Status == StatusPaused) && !info.IsRunning() {
+		inspect.Status = StatusExited
+	}
+	if inspect.Status != StatusExited {
+		if inspect.Pids, err = NewCgroup(info.CgroupId).PidsCurrent(); err != nil {
+			return nil, fmt.Errorf("can't read the number of processes: %w", err)
+		}
+	}
+	return inspect, nil
+}
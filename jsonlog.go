@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogEntry is a line of output of a container, stored one json object per
+// line like the json-file log driver of docker.
+type LogEntry struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+type JsonLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func OpenJsonLog(containerName string) (*JsonLog, error) {
+	file, err := os.OpenFile(makeContainerLogPath(containerName), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &JsonLog{file: file}, nil
+}
+
+func (l *JsonLog) Close() error {
+	return l.file.Close()
+}
+
+// Writer returns a writer which logs everything written to it as the given
+// stream, one entry per line.
+func (l *JsonLog) Writer(stream string) io.Writer {
+	return &streamWriter{log: l, stream: stream}
+}
+
+func (l *JsonLog) write(stream string, data []byte) error {
+	now := time.Now().UTC()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[0 : i+1]
+		}
+		data = data[len(line):]
+
+		entry, err := json.Marshal(LogEntry{Log: string(line), Stream: stream, Time: now})
+		if err != nil {
+			return err
+		}
+		if _, err := l.file.Write(append(entry, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type streamWriter struct {
+	log    *JsonLog
+	stream string
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if err := w.log.write(w.stream, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
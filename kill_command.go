@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var killCommand = cli.Command{
+	Name:      "kill",
+	Usage:     "kill one or more running containers",
+	UsageText: `mydocker kill [OPTIONS] CONTAINER...`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "s",
+			Value: "SIGKILL",
+			Usage: "signal to send to the container",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) == 0 {
+			return fmt.Errorf("missing container name")
+		}
+		sig, err := parseSignal(ctx.String("s"))
+		if err != nil {
+			return err
+		}
+		for _, name := range ctx.Args() {
+			info, err := FindContainer(name)
+			if err != nil {
+				return err
+			}
+			if !info.IsRunning() {
+				return fmt.Errorf("the container `%v` is not running", name)
+			}
+			if info.Status == StatusPaused {
+				return fmt.Errorf("the container `%v` is paused; unpause it before killing it", name)
+			}
+			if err := info.Signal(sig); err != nil {
+				return err
+			}
+			// Not every signal terminates the container.
+			if info.WaitExit(time.Second) {
+				if err := reapContainer(info.Name, 128+int(sig)); err != nil {
+					return err
+				}
+			}
+			fmt.Println(name)
+		}
+		return nil
+	},
+}
+
+var signals = map[string]syscall.Signal{
+	"HUP":    syscall.SIGHUP,
+	"INT":    syscall.SIGINT,
+	"QUIT":   syscall.SIGQUIT,
+	"ILL":    syscall.SIGILL,
+	"TRAP":   syscall.SIGTRAP,
+	"ABRT":   syscall.SIGABRT,
+	"BUS":    syscall.SIGBUS,
+	"FPE":    syscall.SIGFPE,
+	"KILL":   syscall.SIGKILL,
+	"USR1":   syscall.SIGUSR1,
+	"SEGV":   syscall.SIGSEGV,
+	"USR2":   syscall.SIGUSR2,
+	"PIPE":   syscall.SIGPIPE,
+	"ALRM":   syscall.SIGALRM,
+	"TERM":   syscall.SIGTERM,
+	"CHLD":   syscall.SIGCHLD,
+	"CONT":   syscall.SIGCONT,
+	"STOP":   syscall.SIGSTOP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"URG":    syscall.SIGURG,
+	"XCPU":   syscall.SIGXCPU,
+	"XFSZ":   syscall.SIGXFSZ,
+	"VTALRM": syscall.SIGVTALRM,
+	"PROF":   syscall.SIGPROF,
+	"WINCH":  syscall.SIGWINCH,
+	"IO":     syscall.SIGIO,
+	"PWR":    syscall.SIGPWR,
+	"SYS":    syscall.SIGSYS,
+}
+
+// parseSignal accepts a signal number or a name with or without the SIG
+// prefix, e.g. 9, KILL or SIGKILL.
+func parseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n > 64 {
+			return 0, fmt.Errorf("bad signal number %v", n)
+		}
+		return syscall.Signal(n), nil
+	}
+	sig, exist := signals[strings.TrimPrefix(strings.ToUpper(s), "SIG")]
+	if !exist {
+		return 0, fmt.Errorf("unknown signal `%v`", s)
+	}
+	return sig, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+var logsCommand = cli.Command{
+	Name:      "logs",
+	Usage:     "fetch the logs of a container",
+	UsageText: `mydocker logs [OPTIONS] CONTAINER`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "follow, f",
+			Usage: "follow log output",
+		},
+		cli.StringFlag{
+			Name:  "tail",
+			Value: "all",
+			Usage: "number of lines to show from the end of the logs",
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "show logs since timestamp (e.g. 2013-01-02T13:23:37Z) or relative (e.g. 42m for 42 minutes)",
+		},
+		cli.BoolFlag{
+			Name:  "timestamps, t",
+			Usage: "show timestamps",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 1 {
+			return fmt.Errorf("missing container name")
+		}
+		info, err := FindContainer(ctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		tail := -1
+		if ctx.String("tail") != "all" {
+			if tail, err = strconv.Atoi(ctx.String("tail")); err != nil || tail < 0 {
+				return fmt.Errorf("bad --tail `%v`", ctx.String("tail"))
+			}
+		}
+		var since time.Time
+		if ctx.String("since") != "" {
+			if since, err = parseSince(ctx.String("since")); err != nil {
+				return err
+			}
+		}
+
+		file, err := os.Open(makeContainerLogPath(info.Name))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		printer := logPrinter{since: since, timestamps: ctx.Bool("timestamps")}
+		reader := bufio.NewReader(file)
+		entries, partial, err := readLogEntries(reader)
+		if err != nil {
+			return err
+		}
+		if tail >= 0 && len(entries) > tail {
+			entries = entries[len(entries)-tail:]
+		}
+		for _, entry := range entries {
+			printer.print(entry)
+		}
+		if !ctx.Bool("follow") {
+			return nil
+		}
+
+		// Keep polling for new entries until the container exits.
+		for {
+			running := true
+			if current, err := NewContainerInfo(info.Name); err != nil || !current.IsRunning() {
+				running = false
+			}
+			line, err := reader.ReadBytes('\n')
+			partial = append(partial, line...)
+			if err == io.EOF {
+				if !running {
+					return nil
+				}
+				time.Sleep(200 * time.Millisecond)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			var entry LogEntry
+			if err := json.Unmarshal(partial, &entry); err != nil {
+				return err
+			}
+			partial = nil
+			printer.print(entry)
+		}
+	},
+}
+
+// readLogEntries reads all the complete entries up to the end of the log and
+// returns the trailing partially written line, if any.
+func readLogEntries(reader *bufio.Reader) ([]LogEntry, []byte, error) {
+	var entries []LogEntry
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return entries, line, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		var entry LogEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+type logPrinter struct {
+	since      time.Time
+	timestamps bool
+}
+
+func (p *logPrinter) print(entry LogEntry) {
+	if entry.Time.Before(p.since) {
+		return
+	}
+	out := os.Stdout
+	if entry.Stream == "stderr" {
+		out = os.Stderr
+	}
+	if p.timestamps {
+		fmt.Fprintf(out, "%s %s", entry.Time.Format(time.RFC3339Nano), entry.Log)
+	} else {
+		fmt.Fprint(out, entry.Log)
+	}
+}
+
+// parseSince accepts either an RFC 3339 timestamp or a duration relative to
+// now.
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad --since `%v`", s)
+	}
+	return t, nil
+}
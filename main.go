@@ -7,6 +7,12 @@ import (
 )
 
 func main() {
+	if err := newApp().Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func newApp() *cli.App {
 	app := cli.NewApp()
 	app.Name = "mydocker"
 	app.Usage = `mydocker is a simple container`
@@ -16,9 +22,20 @@ func main() {
 		importCommand,
 		commitCommand,
 		networkCommand,
+		psCommand,
+		stopCommand,
+		killCommand,
+		rmCommand,
+		execCommand,
+		logsCommand,
+		waitCommand,
+		attachCommand,
+		inspectCommand,
+		pauseCommand,
+		unpauseCommand,
+		statsCommand,
+		updateCommand,
+		portCommand,
 	}
-
-	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
-	}
+	return app
 }
@@ -241,9 +241,12 @@ func Connect(networkName string, container *Container) error {
 	if err != nil {
 		return err
 	}
+	// Leave nothing behind on failure.
 	defer func() {
 		if (err != nil) {
+			drivers[network.Driver].Disconnect(*container)
 			ipAllocator.Release(network.IpNet, ip)
+			container.ip = nil
 		}
 	}()
 	
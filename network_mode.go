@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"github.com/vishvananda/netns"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// The types of network modes.
+const (
+	// A network namespace of its own, with only the loopback.
+	NetworkNone = "none"
+	// The network namespace of the host.
+	NetworkHost = "host"
+	// A network namespace of its own, connected to a bridge network.
+	NetworkBridge = "bridge"
+	// The network namespace of another container.
+	NetworkContainer = "container"
+)
+
+// NetworkMode is how a container is networked, as given by --net.
+type NetworkMode struct {
+	Type string
+	// The bridge network with NetworkBridge, or the container whose network
+	// is joined with NetworkContainer.
+	Name string
+}
+
+// ParseNetworkMode parses a --net option: none, host, bridge:NETWORK,
+// container:CONTAINER, or a bare NETWORK for bridge:NETWORK as before. The
+// host network is used when none is given.
+func ParseNetworkMode(s string) (NetworkMode, error) {
+	switch s {
+	case "", NetworkHost:
+		return NetworkMode{Type: NetworkHost}, nil
+	case NetworkNone:
+		return NetworkMode{Type: NetworkNone}, nil
+	}
+	kv := strings.SplitN(s, ":", 2)
+	if len(kv) == 1 {
+		return NetworkMode{Type: NetworkBridge, Name: s}, nil
+	}
+	switch kv[0] {
+	case NetworkBridge, NetworkContainer:
+		if kv[1] == "" {
+			return NetworkMode{}, fmt.Errorf("bad network mode `%v`: missing the %v name", s, kv[0])
+		}
+		return NetworkMode{Type: kv[0], Name: kv[1]}, nil
+	}
+	return NetworkMode{}, fmt.Errorf("bad network mode `%v`", s)
+}
+
+func (m NetworkMode) String() string {
+	if m.Name == "" {
+		return m.Type
+	}
+	return m.Type + ":" + m.Name
+}
+
+// makeCloneFlags returns the namespaces to create for init. Only the none
+// and bridge modes create a network namespace; the others run init in an
+// existing one, which it inherits from the thread starting it.
+func makeCloneFlags(mode NetworkMode) uintptr {
+	flags := syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	if mode.Type == NetworkNone || mode.Type == NetworkBridge {
+		flags |= syscall.CLONE_NEWNET
+	}
+	return uintptr(flags)
+}
+
+// startInNetns starts the command in the network namespace, by switching
+// the thread which forks it there for the time of the fork.
+func startInNetns(cmd *exec.Cmd, ns netns.NsHandle) error {
+	runtime.LockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer origin.Close()
+	if err := netns.Set(ns); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+
+	err = cmd.Start()
+	if restoreErr := netns.Set(origin); restoreErr != nil {
+		// Leave the thread locked, so that nothing else runs in the wrong
+		// network namespace.
+		if err == nil {
+			err = fmt.Errorf("can't return to the network namespace: %w", restoreErr)
+		}
+		return err
+	}
+	runtime.UnlockOSThread()
+	return err
+}
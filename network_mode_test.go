@@ -0,0 +1,47 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseNetworkModeCloneFlags(t *testing.T) {
+	tests := []struct {
+		net    string
+		mode   NetworkMode
+		newNet bool
+	}{
+		{"", NetworkMode{Type: NetworkHost}, false},
+		{"host", NetworkMode{Type: NetworkHost}, false},
+		{"none", NetworkMode{Type: NetworkNone}, true},
+		{"bridge:x", NetworkMode{Type: NetworkBridge, Name: "x"}, true},
+		{"x", NetworkMode{Type: NetworkBridge, Name: "x"}, true},
+		{"container:x", NetworkMode{Type: NetworkContainer, Name: "x"}, false},
+	}
+	for _, test := range tests {
+		mode, err := ParseNetworkMode(test.net)
+		if err != nil {
+			t.Errorf("%q: %v", test.net, err)
+			continue
+		}
+		if mode != test.mode {
+			t.Errorf("%q: got mode %+v, want %+v", test.net, mode, test.mode)
+		}
+		flags := makeCloneFlags(mode)
+		if newNet := flags&syscall.CLONE_NEWNET != 0; newNet != test.newNet {
+			t.Errorf("%q: got CLONE_NEWNET %v, want %v", test.net, newNet, test.newNet)
+		}
+		const always = syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+		if flags&always != always {
+			t.Errorf("%q: got clone flags %#x, missing some of %#x", test.net, flags, always)
+		}
+	}
+}
+
+func TestParseNetworkModeErrors(t *testing.T) {
+	for _, net := range []string{"bridge:", "container:", "foo:bar"} {
+		if mode, err := ParseNetworkMode(net); err == nil {
+			t.Errorf("%q: got mode %+v, want an error", net, mode)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+/*
+#define _GNU_SOURCE
+#include <errno.h>
+#include <fcntl.h>
+#include <sched.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/wait.h>
+#include <unistd.h>
+
+#define MAX_CGROUPS 16
+
+// Join the namespaces of the container whose pid is given in the environment,
+// and exec the command in argv, which is `mydocker exec CONTAINER COMMAND...`.
+// This has to run before the Go runtime starts any thread, because a
+// multithreaded process can't join a mount namespace. Glibc passes argc and
+// argv to constructors.
+__attribute__((constructor)) static void enter_namespaces(int argc, char **argv) {
+	const char *pid = getenv("mydocker_pid");
+	if (pid == NULL) {
+		return;
+	}
+	if (argc < 4) {
+		fprintf(stderr, "missing command\n");
+		exit(1);
+	}
+
+	// The cgroup.procs files of the cgroups to join, separated by colons,
+	// are opened while they can still be resolved on the host.
+	int cgroup_fds[MAX_CGROUPS];
+	int cgroup_count = 0;
+	const char *cgroups = getenv("mydocker_cgroups");
+	if (cgroups != NULL && *cgroups != '\0') {
+		char *paths = strdup(cgroups);
+		for (char *path = strtok(paths, ":"); path != NULL; path = strtok(NULL, ":")) {
+			if (cgroup_count == MAX_CGROUPS) {
+				fprintf(stderr, "too many cgroups to join\n");
+				exit(1);
+			}
+			cgroup_fds[cgroup_count] = open(path, O_WRONLY | O_CLOEXEC);
+			if (cgroup_fds[cgroup_count] < 0) {
+				fprintf(stderr, "can't open %s: %s\n", path, strerror(errno));
+				exit(1);
+			}
+			cgroup_count++;
+		}
+		free(paths);
+	}
+
+	// The mount namespace goes last since /proc is resolved in it.
+	const char *namespaces[] = {"ipc", "uts", "net", "pid", "mnt"};
+	const int count = sizeof(namespaces) / sizeof(namespaces[0]);
+	int fds[count];
+	char path[64];
+	for (int i = 0; i < count; i++) {
+		snprintf(path, sizeof(path), "/proc/%s/ns/%s", pid, namespaces[i]);
+		fds[i] = open(path, O_RDONLY | O_CLOEXEC);
+		if (fds[i] < 0) {
+			fprintf(stderr, "can't open %s: %s\n", path, strerror(errno));
+			exit(1);
+		}
+	}
+	for (int i = 0; i < count; i++) {
+		if (setns(fds[i], 0) < 0) {
+			fprintf(stderr, "can't join %s namespace: %s\n", namespaces[i], strerror(errno));
+			exit(1);
+		}
+		close(fds[i]);
+	}
+
+	// Joining a pid namespace only moves the children of the caller into it.
+	// So the command runs in a child while we pass on its exit status.
+	pid_t child = fork();
+	if (child < 0) {
+		fprintf(stderr, "can't fork: %s\n", strerror(errno));
+		exit(1);
+	}
+	if (child > 0) {
+		int status;
+		while (waitpid(child, &status, 0) < 0) {
+			if (errno != EINTR) {
+				exit(1);
+			}
+		}
+		if (WIFSIGNALED(status)) {
+			exit(128 + WTERMSIG(status));
+		}
+		exit(WEXITSTATUS(status));
+	}
+
+	// Only the command goes into the cgroups, without the threads of the Go
+	// runtime, which would count against the pids limit; writing 0 moves
+	// the writer.
+	for (int i = 0; i < cgroup_count; i++) {
+		if (write(cgroup_fds[i], "0", 1) < 0) {
+			fprintf(stderr, "can't join cgroup: %s\n", strerror(errno));
+			exit(1);
+		}
+		close(cgroup_fds[i]);
+	}
+	const char *cwd = getenv("mydocker_cwd");
+	if (cwd != NULL && chdir(cwd) < 0) {
+		fprintf(stderr, "can't change directory to %s: %s\n", cwd, strerror(errno));
+		exit(1);
+	}
+	unsetenv("mydocker_pid");
+	unsetenv("mydocker_cwd");
+	unsetenv("mydocker_cgroups");
+	execvp(argv[3], argv + 3);
+	fprintf(stderr, "can't exec %s: %s\n", argv[3], strerror(errno));
+	exit(errno == ENOENT ? 127 : 126);
+}
+*/
+import "C"
+
+// The environment variables by which `mydocker exec` tells its re-executed
+// self which container to enter. Variables with ReservedEnvPrefix are never
+// taken from the container environment, since setting them would make
+// mydocker enter the namespaces of any process.
+const (
+	ReservedEnvPrefix = "mydocker_"
+	ExecPidEnv        = ReservedEnvPrefix + "pid"
+	ExecCwdEnv        = ReservedEnvPrefix + "cwd"
+	// The cgroup.procs files of the cgroups of the container, separated by
+	// colons.
+	ExecCgroupsEnv = ReservedEnvPrefix + "cgroups"
+)
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+)
+
+var pauseCommand = cli.Command{
+	Name:      "pause",
+	Usage:     "pause all processes within one or more containers",
+	UsageText: `mydocker pause CONTAINER...`,
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) == 0 {
+			return fmt.Errorf("missing container name")
+		}
+		for _, name := range ctx.Args() {
+			if err := pauseContainer(name, true); err != nil {
+				return err
+			}
+			fmt.Println(name)
+		}
+		return nil
+	},
+}
+
+var unpauseCommand = cli.Command{
+	Name:      "unpause",
+	Usage:     "unpause all processes within one or more containers",
+	UsageText: `mydocker unpause CONTAINER...`,
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) == 0 {
+			return fmt.Errorf("missing container name")
+		}
+		for _, name := range ctx.Args() {
+			if err := pauseContainer(name, false); err != nil {
+				return err
+			}
+			fmt.Println(name)
+		}
+		return nil
+	},
+}
+
+// pauseContainer freezes or thaws the processes of the container, and
+// records it in the state once the kernel is done.
+func pauseContainer(nameOrId string, pause bool) error {
+	info, err := FindContainer(nameOrId)
+	if err != nil {
+		return err
+	}
+	lock, err := lockContainer(info.Name)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if info, err = NewContainerInfo(info.Name); err != nil {
+		return err
+	}
+
+	if !info.IsRunning() {
+		return fmt.Errorf("the container `%v` is not running", nameOrId)
+	}
+	cgroup := NewCgroup(info.CgroupId)
+	if pause {
+		if info.Status == StatusPaused {
+			return fmt.Errorf("the container `%v` is already paused", nameOrId)
+		}
+		if err := cgroup.Freeze(); err != nil {
+			return err
+		}
+		info.Status = StatusPaused
+	} else {
+		if info.Status != StatusPaused {
+			return fmt.Errorf("the container `%v` is not paused", nameOrId)
+		}
+		if err := cgroup.Thaw(); err != nil {
+			return err
+		}
+		info.Status = StatusRunning
+	}
+	return info.Save()
+}
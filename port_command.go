@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"strconv"
+	"strings"
+)
+
+var portCommand = cli.Command{
+	Name:      "port",
+	Usage:     "list port mappings or a specific mapping for the container",
+	UsageText: `mydocker port CONTAINER [PRIVATE_PORT[/PROTOCOL]]`,
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) == 0 {
+			return fmt.Errorf("missing container name")
+		}
+		if len(ctx.Args()) > 2 {
+			return fmt.Errorf("too many arguments")
+		}
+		info, err := FindContainer(ctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+
+		port, protocol := 0, ""
+		if len(ctx.Args()) == 2 {
+			spec := ctx.Args().Get(1)
+			protocol = "tcp"
+			if i := strings.Index(spec, "/"); i >= 0 {
+				spec, protocol = spec[:i], spec[i+1:]
+			}
+			if port, err = strconv.Atoi(spec); err != nil {
+				return fmt.Errorf("bad port `%v`", ctx.Args().Get(1))
+			}
+		}
+		found := false
+		for _, mapping := range info.Ports {
+			if port != 0 && (mapping.ContainerPort != port || mapping.Protocol != protocol) {
+				continue
+			}
+			found = true
+			if port != 0 {
+				// Only the host side, as with `docker port`.
+				hostIP := mapping.HostIP
+				if hostIP == "" {
+					hostIP = "0.0.0.0"
+				}
+				fmt.Printf("%s:%d\n", hostIP, mapping.HostPort)
+				continue
+			}
+			fmt.Println(mapping)
+		}
+		if port != 0 && !found {
+			return fmt.Errorf("no public port `%v` published for the container `%v`", ctx.Args().Get(1), ctx.Args().Get(0))
+		}
+		return nil
+	},
+}
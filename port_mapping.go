@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// PortMapping publishes a port of a container on a port of the host.
+type PortMapping struct {
+	// The host address to publish on, or empty for all of them.
+	HostIP        string
+	HostPort      int
+	ContainerPort int
+	// tcp or udp.
+	Protocol string
+}
+
+func (p PortMapping) String() string {
+	hostIP := p.HostIP
+	if hostIP == "" {
+		hostIP = "0.0.0.0"
+	}
+	return fmt.Sprintf("%d/%s -> %s:%d", p.ContainerPort, p.Protocol, hostIP, p.HostPort)
+}
+
+// parsePublish parses a --publish option like
+// [HOST_IP:][HOST_PORT:]CONTAINER_PORT[/PROTOCOL], where the ports may be
+// ranges like 8000-8009 of the same length. A missing host port is picked
+// among the free ones.
+func parsePublish(spec string) ([]PortMapping, error) {
+	protocol := "tcp"
+	if i := strings.LastIndex(spec, "/"); i >= 0 {
+		protocol = spec[i+1:]
+		spec = spec[:i]
+	}
+	if protocol != "tcp" && protocol != "udp" {
+		return nil, fmt.Errorf("bad protocol `%v`, expected tcp or udp", protocol)
+	}
+
+	var hostIP, hostPorts, containerPorts string
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 1:
+		containerPorts = parts[0]
+	case 2:
+		hostPorts, containerPorts = parts[0], parts[1]
+	case 3:
+		hostIP, hostPorts, containerPorts = parts[0], parts[1], parts[2]
+	default:
+		return nil, fmt.Errorf("bad format `%v`, expected [HOST_IP:][HOST_PORT:]CONTAINER_PORT[/PROTOCOL]", spec)
+	}
+	if hostIP != "" {
+		if ip := net.ParseIP(hostIP); ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("bad host IPv4 address `%v`", hostIP)
+		}
+	}
+	containerStart, containerEnd, err := parsePortRange(containerPorts)
+	if err != nil {
+		return nil, err
+	}
+	hostStart, hostEnd := 0, 0
+	if hostPorts != "" {
+		if hostStart, hostEnd, err = parsePortRange(hostPorts); err != nil {
+			return nil, err
+		}
+		if hostEnd-hostStart != containerEnd-containerStart {
+			return nil, fmt.Errorf("the host and container port ranges of `%v` have different lengths", spec)
+		}
+	}
+
+	var mappings []PortMapping
+	for port := containerStart; port <= containerEnd; port++ {
+		mapping := PortMapping{
+			HostIP:        hostIP,
+			ContainerPort: port,
+			Protocol:      protocol,
+		}
+		if hostPorts != "" {
+			mapping.HostPort = hostStart + port - containerStart
+		} else if mapping.HostPort, err = findFreePort(hostIP, protocol); err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+func parsePortRange(s string) (int, int, error) {
+	parsePort := func(s string) (int, error) {
+		port, err := strconv.Atoi(s)
+		if err != nil || port < 1 || port > 65535 {
+			return 0, fmt.Errorf("bad port `%v`", s)
+		}
+		return port, nil
+	}
+	bounds := strings.SplitN(s, "-", 2)
+	start, err := parsePort(bounds[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	end := start
+	if len(bounds) == 2 {
+		if end, err = parsePort(bounds[1]); err != nil {
+			return 0, 0, err
+		}
+		if end < start {
+			return 0, 0, fmt.Errorf("bad port range `%v`", s)
+		}
+	}
+	return start, end, nil
+}
+
+// findFreePort asks the kernel for a port which nothing listens on.
+func findFreePort(hostIP, protocol string) (int, error) {
+	return bindPort(hostIP, protocol, 0)
+}
+
+// bindPort binds the port, or any free port if it's 0, and releases it at
+// once. It returns the port bound.
+func bindPort(hostIP, protocol string, port int) (int, error) {
+	address := net.JoinHostPort(hostIP, strconv.Itoa(port))
+	if protocol == "udp" {
+		conn, err := net.ListenPacket("udp4", address)
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).Port, nil
+	}
+	listener, err := net.Listen("tcp4", address)
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// checkPortConflicts fails if two port mappings publish the same host port,
+// if one is already published by a running container, or if a host process
+// is using one, whose connections the DNAT rule would take over.
+func checkPortConflicts(mappings []PortMapping) error {
+	for i, mapping := range mappings {
+		for _, other := range mappings[:i] {
+			if portsConflict(mapping, other) {
+				return fmt.Errorf("the port %d/%s is published more than once", mapping.HostPort, mapping.Protocol)
+			}
+		}
+	}
+	containers, err := ListContainers()
+	if err != nil {
+		return err
+	}
+	for _, info := range containers {
+		if !info.IsRunning() {
+			continue
+		}
+		for _, used := range info.Ports {
+			for _, mapping := range mappings {
+				if portsConflict(used, mapping) {
+					return fmt.Errorf("the port %d/%s is already published by the container `%v`", mapping.HostPort, mapping.Protocol, info.Name)
+				}
+			}
+		}
+	}
+	for _, mapping := range mappings {
+		if _, err := bindPort(mapping.HostIP, mapping.Protocol, mapping.HostPort); err != nil {
+			return fmt.Errorf("the port %d/%s is not available on the host: %w", mapping.HostPort, mapping.Protocol, err)
+		}
+	}
+	return nil
+}
+
+// portsConflict tells whether two port mappings publish the same host port
+// on a common address.
+func portsConflict(a, b PortMapping) bool {
+	if a.HostPort != b.HostPort || a.Protocol != b.Protocol {
+		return false
+	}
+	return a.HostIP == "" || b.HostIP == "" || a.HostIP == b.HostIP
+}
+
+// The nat chain holding the DNAT rules of the published ports.
+const PortMappingChain = "MYDOCKER"
+
+func iptables(args ...string) error {
+	cmd := exec.Command("iptables", args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("iptables failed: output=%s, err=%w", output, err)
+	}
+	return nil
+}
+
+// deleteRule deletes the rule unless it's already gone.
+func deleteRule(table, chain string, rule ...string) error {
+	check := append([]string{"-t", table, "-C", chain}, rule...)
+	if exec.Command("iptables", check...).Run() != nil {
+		return nil
+	}
+	return iptables(append([]string{"-t", table, "-D", chain}, rule...)...)
+}
+
+// ensureRule appends the rule unless it's already there.
+func ensureRule(table, chain string, rule ...string) error {
+	check := append([]string{"-t", table, "-C", chain}, rule...)
+	if exec.Command("iptables", check...).Run() == nil {
+		return nil
+	}
+	return iptables(append([]string{"-t", table, "-A", chain}, rule...)...)
+}
+
+// setUpPortMappingChain creates the chain of the published ports, which
+// catches the packets to any local address, from outside or from the host
+// itself. It also lets the host reach the published ports through its
+// loopback address, which needs the packets from 127.0.0.1 to be routed to
+// the bridge and masqueraded there.
+func setUpPortMappingChain(bridgeName string) error {
+	if exec.Command("iptables", "-t", "nat", "-n", "-L", PortMappingChain).Run() != nil {
+		if err := iptables("-t", "nat", "-N", PortMappingChain); err != nil {
+			return err
+		}
+	}
+	if err := ensureRule("nat", "PREROUTING", "-m", "addrtype", "--dst-type", "LOCAL", "-j", PortMappingChain); err != nil {
+		return err
+	}
+	if err := ensureRule("nat", "OUTPUT", "-m", "addrtype", "--dst-type", "LOCAL", "-j", PortMappingChain); err != nil {
+		return err
+	}
+	if err := ensureRule("nat", "POSTROUTING", "-s", "127.0.0.0/8", "-o", bridgeName, "-j", "MASQUERADE"); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fmt.Sprintf("/proc/sys/net/ipv4/conf/%s/route_localnet", bridgeName), []byte("1"), 0644)
+}
+
+// tearDownPortMappingChain undoes setUpPortMappingChain for a bridge being
+// removed. The chain and the jumps to it are shared by all the bridges, and
+// go with the last one.
+func tearDownPortMappingChain(bridgeName string, lastBridge bool) error {
+	if err := deleteRule("nat", "POSTROUTING", "-s", "127.0.0.0/8", "-o", bridgeName, "-j", "MASQUERADE"); err != nil {
+		return err
+	}
+	if !lastBridge {
+		return nil
+	}
+	if err := deleteRule("nat", "PREROUTING", "-m", "addrtype", "--dst-type", "LOCAL", "-j", PortMappingChain); err != nil {
+		return err
+	}
+	if err := deleteRule("nat", "OUTPUT", "-m", "addrtype", "--dst-type", "LOCAL", "-j", PortMappingChain); err != nil {
+		return err
+	}
+	if exec.Command("iptables", "-t", "nat", "-n", "-L", PortMappingChain).Run() != nil {
+		return nil
+	}
+	if err := iptables("-t", "nat", "-F", PortMappingChain); err != nil {
+		return err
+	}
+	return iptables("-t", "nat", "-X", PortMappingChain)
+}
+
+type iptablesRule struct {
+	table string
+	chain string
+	args  []string
+}
+
+// portMappingRules returns the rules of a port mapping: the DNAT to the
+// container, and the masquerading of a container reaching its own published
+// port, whose reply must go back through the host.
+func portMappingRules(mapping PortMapping, containerIP net.IP) []iptablesRule {
+	port := strconv.Itoa(mapping.ContainerPort)
+	dnat := []string{"-p", mapping.Protocol}
+	if mapping.HostIP != "" {
+		dnat = append(dnat, "-d", mapping.HostIP)
+	}
+	dnat = append(dnat, "--dport", strconv.Itoa(mapping.HostPort),
+		"-j", "DNAT", "--to-destination", net.JoinHostPort(containerIP.String(), port))
+	hairpin := []string{"-p", mapping.Protocol, "-s", containerIP.String(), "-d", containerIP.String(),
+		"--dport", port, "-j", "MASQUERADE"}
+	return []iptablesRule{
+		{table: "nat", chain: PortMappingChain, args: dnat},
+		{table: "nat", chain: "POSTROUTING", args: hairpin},
+	}
+}
+
+// addPortMappings installs the rules of the port mappings of a container.
+func addPortMappings(bridgeName string, mappings []PortMapping, containerIP net.IP) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+	if err := setUpPortMappingChain(bridgeName); err != nil {
+		return err
+	}
+	for _, mapping := range mappings {
+		for _, rule := range portMappingRules(mapping, containerIP) {
+			if err := iptables(append([]string{"-t", rule.table, "-A", rule.chain}, rule.args...)...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removePortMappings deletes the rules of the port mappings of a container,
+// ignoring those already gone so that it can be done again.
+func removePortMappings(mappings []PortMapping, containerIP net.IP) error {
+	for _, mapping := range mappings {
+		for _, rule := range portMappingRules(mapping, containerIP) {
+			if err := deleteRule(rule.table, rule.chain, rule.args...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
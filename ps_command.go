@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var psCommand = cli.Command{
+	Name:      "ps",
+	Usage:     "list containers",
+	UsageText: `mydocker ps [OPTIONS]`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "a",
+			Usage: "show all containers (default shows just running)",
+		},
+		cli.BoolFlag{
+			Name:  "q",
+			Usage: "only display container ids",
+		},
+		cli.StringSliceFlag{
+			Name:  "filter, f",
+			Usage: "filter output based on conditions provided: id, name, image, status",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		filters, err := parsePsFilters(ctx.StringSlice("filter"))
+		if err != nil {
+			return err
+		}
+		containers, err := ListContainers()
+		if err != nil {
+			return err
+		}
+		sort.Slice(containers, func(i, j int) bool {
+			return containers[i].CreatedAt.After(containers[j].CreatedAt)
+		})
+
+		writer := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+		if !ctx.Bool("q") {
+			fmt.Fprint(writer, "CONTAINER ID\tIMAGE\tCOMMAND\tCREATED\tSTATUS\tNAMES\n")
+		}
+		for _, c := range containers {
+			if (c.Status == StatusRunning || c.Status == StatusPaused) && !c.IsRunning() {
+				c.Status = StatusExited
+			}
+			if !ctx.Bool("a") && c.Status != StatusRunning && c.Status != StatusPaused {
+				continue
+			}
+			if !matchPsFilters(c, filters) {
+				continue
+			}
+			if ctx.Bool("q") {
+				fmt.Fprintf(writer, "%s\n", makeShortId(c.Id))
+				continue
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				makeShortId(c.Id),
+				c.Image,
+				strconv.Quote(truncate(strings.Join(c.Command, " "), 20)),
+				humanDuration(time.Since(c.CreatedAt))+" ago",
+				formatStatus(c),
+				c.Name)
+		}
+		return writer.Flush()
+	},
+}
+
+type psFilter struct {
+	key   string
+	value string
+}
+
+func parsePsFilters(args []string) ([]psFilter, error) {
+	var filters []psFilter
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad filter `%v`; expecting KEY=VALUE", arg)
+		}
+		switch kv[0] {
+		case "id", "name", "image", "status":
+		default:
+			return nil, fmt.Errorf("unknown filter `%v`", kv[0])
+		}
+		filters = append(filters, psFilter{key: kv[0], value: kv[1]})
+	}
+	return filters, nil
+}
+
+// matchPsFilters reports whether the container matches all the filters.
+func matchPsFilters(c ContainerInfo, filters []psFilter) bool {
+	for _, f := range filters {
+		var ok bool
+		switch f.key {
+		case "id":
+			ok = strings.HasPrefix(c.Id, f.value)
+		case "name":
+			ok = strings.Contains(c.Name, f.value)
+		case "image":
+			ok = c.Image == f.value
+		case "status":
+			ok = c.Status == f.value
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func formatStatus(c ContainerInfo) string {
+	switch c.Status {
+	case StatusRunning:
+		return "Up " + humanDuration(time.Since(c.StartedAt))
+	case StatusPaused:
+		return "Up " + humanDuration(time.Since(c.StartedAt)) + " (Paused)"
+	case StatusExited:
+		if c.FinishedAt.IsZero() {
+			return "Exited"
+		}
+		return fmt.Sprintf("Exited (%d) %s ago", c.ExitCode, humanDuration(time.Since(c.FinishedAt)))
+	case StatusCreated:
+		return "Created"
+	}
+	return c.Status
+}
+
+func humanDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%d seconds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%d minutes", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d days", int(d.Hours()/24))
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[0:n-1] + "…"
+}
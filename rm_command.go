@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+var rmCommand = cli.Command{
+	Name:      "rm",
+	Usage:     "remove one or more containers",
+	UsageText: `mydocker rm [OPTIONS] CONTAINER...`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "f",
+			Usage: "force the removal of a running container (uses SIGKILL)",
+		},
+		cli.BoolFlag{
+			Name:  "v",
+			Usage: "remove the volume directories created for the container",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) == 0 {
+			return fmt.Errorf("missing container name")
+		}
+		for _, name := range ctx.Args() {
+			if err := removeContainer(name, ctx.Bool("f"), ctx.Bool("v")); err != nil {
+				return err
+			}
+			fmt.Println(name)
+		}
+		return nil
+	},
+}
+
+func removeContainer(nameOrId string, force bool, removeVolumes bool) error {
+	info, err := FindContainer(nameOrId)
+	if err != nil {
+		return err
+	}
+	if info.IsRunning() {
+		if !force {
+			return fmt.Errorf("the container `%v` is running; stop it first or use -f", nameOrId)
+		}
+		if err := info.Signal(syscall.SIGKILL); err != nil {
+			return err
+		}
+		// A frozen process dies only once thawed.
+		if info.Status == StatusPaused {
+			if err := NewCgroup(info.CgroupId).Thaw(); err != nil {
+				log.Printf("can't thaw container `%v`: %v", info.Name, err)
+			}
+		}
+		info.WaitExit(10*time.Second)
+		if err := reapContainer(info.Name, 128+int(syscall.SIGKILL)); err != nil {
+			log.Printf("can't clean up container `%v`: %v", info.Name, err)
+		}
+	} else if info.Status != StatusExited {
+		if err := finishContainer(info.Name, 0); err != nil {
+			log.Printf("can't clean up container `%v`: %v", info.Name, err)
+		}
+	}
+
+	lock, err := lockContainer(info.Name)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if info, err = NewContainerInfo(info.Name); err != nil {
+		return err
+	}
+	// Release whatever an earlier teardown failed to.
+	if err := teardownContainer(info); err != nil {
+		if !force {
+			return err
+		}
+		log.Printf("can't clean up container `%v`: %v", info.Name, err)
+	}
+	// Never remove the directory with the rootfs or a volume still mounted
+	// inside, which would delete their contents.
+	if err := cleanContainerWorkspace(info); err != nil {
+		return err
+	}
+
+	if removeVolumes {
+		for _, volume := range info.Volumes {
+			if !volume.Created {
+				continue
+			}
+			if err := os.RemoveAll(volume.Source); err != nil {
+				return err
+			}
+		}
+	}
+	return os.RemoveAll(makeContainerDir(info.Name))
+}
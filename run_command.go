@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"github.com/urfave/cli"
+	"github.com/vishvananda/netns"
 	"log"
 	"os"
 	"os/exec"
@@ -10,14 +11,32 @@ import (
 	"syscall"
 	"path"
 	"net"
+	"time"
+	"io/ioutil"
+	"io"
+	"encoding/json"
 )
 
 type RunOptions struct {
-	createTty     bool
+	interactive   bool
+	tty           bool
+	detach        bool
 	containerName string
 	containerId   string
 	imageName     string
-	command       string
+	command       []string
+	env           []string
+	workingDir    string
+	network       NetworkMode
+	hostname      string
+	dns           []string
+	dnsSearch     []string
+	extraHosts    []string
+	user          string
+	init          bool
+	// The device nodes to create in the container.
+	devices []Device
+	ports   []PortMapping
 	volumes []string
 }
 
@@ -26,25 +45,109 @@ type Container struct {
 	pid int
 	ip net.IP
 	peerName string
+	ports []PortMapping
 }
 
 var runCommand = cli.Command{
 	Name:  "run",
 	Usage: "Run a container from an image",
-	UsageText: `mydocker run [OPTIONS] IMAGE COMMAND`,
+	UsageText: `mydocker run [OPTIONS] IMAGE COMMAND [ARG...]
+
+   Options must come before IMAGE; all that follows it is the command.`,
+	UseShortOptionHandling: true,
+	// Don't take the options of the command for ours.
+	SkipArgReorder: true,
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "i",
-			Usage: "enable tty",
+			Usage: "keep STDIN open",
+		},
+		cli.BoolFlag{
+			Name:  "t",
+			Usage: "allocate a pseudo-TTY",
+		},
+		cli.BoolFlag{
+			Name:  "d",
+			Usage: "run container in background and print container ID",
+		},
+		cli.StringFlag{
+			Name:  "detach-keys",
+			Value: DefaultDetachKeys,
+			Usage: "override the key sequence for detaching a container",
+		},
+		cli.StringFlag{
+			Name:   "shim-id",
+			Usage:  "run as the shim of the container with the given id; not intended for external use",
+			Hidden: true,
 		},
 		cli.StringFlag{
 			Name:  "name",
 			Usage: "container name",
 		},
+		cli.StringSliceFlag{
+			Name:  "e",
+			Usage: "set environment variables",
+		},
+		cli.StringSliceFlag{
+			Name:  "env-file",
+			Usage: "read in a file of environment variables",
+		},
+		cli.StringFlag{
+			Name:  "w",
+			Usage: "working directory inside the container",
+		},
+		cli.BoolFlag{
+			Name:  "init",
+			Usage: "run an init inside the container that forwards signals and reaps processes",
+		},
+		cli.StringFlag{
+			Name:  "user, u",
+			Usage: "username or UID, optionally with a group or GID (format: <name|uid>[:<group|gid>])",
+		},
 		cli.StringFlag{
 			Name:  "m",
 			Usage: "memory limit",
 		},
+		cli.StringFlag{
+			Name:  "memory-swap",
+			Usage: "swap limit equal to memory plus swap: -1 to enable unlimited swap",
+		},
+		cli.StringFlag{
+			Name:  "memory-reservation",
+			Usage: "memory soft limit",
+		},
+		cli.StringFlag{
+			Name:  "kernel-memory",
+			Usage: "kernel memory limit",
+		},
+		cli.BoolFlag{
+			Name:  "oom-kill-disable",
+			Usage: "disable OOM killer",
+		},
+		cli.Int64Flag{
+			Name:  "pids-limit",
+			Usage: "tune container pids limit (set -1 for unlimited)",
+		},
+		cli.IntFlag{
+			Name:  "blkio-weight",
+			Usage: "block IO (relative weight), between 10 and 1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-read-bps",
+			Usage: "limit read rate (bytes per second) from a device, e.g. /dev/sda:1mb",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-write-bps",
+			Usage: "limit write rate (bytes per second) to a device, e.g. /dev/sda:1mb",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-read-iops",
+			Usage: "limit read rate (IO per second) from a device, e.g. /dev/sda:1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-write-iops",
+			Usage: "limit write rate (IO per second) to a device, e.g. /dev/sda:1000",
+		},
 		cli.StringFlag{
 			Name:  "cpu-shares",
 			Usage: "cpushare",
@@ -71,10 +174,50 @@ var runCommand = cli.Command{
 		},
 		cli.StringFlag{
 			Name: "net",
-			Usage: "specify which network to connect to; `host` means connecting to host network",
+			Usage: "connect a container to a network: none, host (default), bridge:NETWORK (or just NETWORK), or container:CONTAINER",
+		},
+		cli.StringFlag{
+			Name:  "hostname",
+			Usage: "container host name (default is the short container id)",
+		},
+		cli.StringSliceFlag{
+			Name:  "dns",
+			Usage: "set custom DNS servers",
+		},
+		cli.StringSliceFlag{
+			Name:  "dns-search",
+			Usage: "set custom DNS search domains",
+		},
+		cli.StringSliceFlag{
+			Name:  "device",
+			Usage: "add a host device to the container (HOST[:CONTAINER][:PERMISSIONS])",
+		},
+		cli.StringSliceFlag{
+			Name:  "publish, p",
+			Usage: "publish a container's port(s) to the host ([HOST_IP:][HOST_PORT:]CONTAINER_PORT[/PROTOCOL])",
+		},
+		cli.StringSliceFlag{
+			Name:  "add-host",
+			Usage: "add a custom host-to-IP mapping (HOST:IP)",
 		},
 	},
-	Action: func(ctx *cli.Context) error {
+	Action: func(ctx *cli.Context) (err error) {
+		shimId := ctx.String("shim-id")
+		// As the shim, tell the waiting `mydocker run -d` whether the
+		// container has been started. Success is reported explicitly, so
+		// that a shim failing in any way is never taken for a success.
+		var ready *os.File
+		if shimId != "" {
+			ready = os.NewFile(ShimReadyPipe, "ready")
+			defer func() {
+				if ready != nil {
+					if err != nil {
+						ready.WriteString(err.Error())
+					}
+					ready.Close()
+				}
+			}()
+		}
 		if len(ctx.Args()) < 2 {
 			return fmt.Errorf("Missing image or command")
 		}
@@ -85,29 +228,217 @@ var runCommand = cli.Command{
 			argArray = append(argArray, arg)
 		}
 		runOpts.imageName = argArray[0]
-		runOpts.command = strings.Join(argArray[1:], " ")
+		runOpts.command = argArray[1:]
 		runOpts.containerName = ctx.String("name")
-		runOpts.createTty = ctx.Bool("i")
-		runOpts.containerId = makeContainerId()
+		runOpts.interactive = ctx.Bool("i")
+		runOpts.tty = ctx.Bool("t")
+		runOpts.detach = ctx.Bool("d")
+		if runOpts.interactive && runOpts.detach && !runOpts.tty {
+			return fmt.Errorf("the -i and -d options conflict without -t")
+		}
+		detachKeys, err := parseDetachKeys(ctx.String("detach-keys"))
+		if err != nil {
+			return err
+		}
+		runOpts.containerId = shimId
+		if shimId == "" {
+			runOpts.containerId = makeContainerId()
+		}
 		if runOpts.containerName == "" {
 			runOpts.containerName = runOpts.containerId
 		}
+		if err := validateContainerName(runOpts.containerName); err != nil {
+			return err
+		}
+		runOpts.env, err = makeContainerEnv(ctx.StringSlice("env-file"), ctx.StringSlice("e"))
+		if err != nil {
+			return err
+		}
+		if _, exist := lookupEnv(runOpts.env, "TERM"); !exist && runOpts.tty {
+			runOpts.env = append(runOpts.env, "TERM=xterm")
+		}
+		runOpts.user = ctx.String("user")
+		runOpts.init = ctx.Bool("init")
+		runOpts.workingDir = ctx.String("w")
+		if runOpts.workingDir != "" && !path.IsAbs(runOpts.workingDir) {
+			return fmt.Errorf("the working directory `%v` is not an absolute path", runOpts.workingDir)
+		}
+		if runOpts.network, err = ParseNetworkMode(ctx.String("net")); err != nil {
+			return err
+		}
+		// Hold on to the network namespace of the container to join, so
+		// that it can't go away before we start.
+		var joinedNetns netns.NsHandle = -1
+		var joinedIP net.IP
+		if runOpts.network.Type == NetworkContainer {
+			joined, err := FindContainer(runOpts.network.Name)
+			if err != nil {
+				return err
+			}
+			if !joined.IsRunning() {
+				return fmt.Errorf("the container `%v` to join the network of is not running", runOpts.network.Name)
+			}
+			if joinedNetns, err = netns.GetFromPid(joined.Pid); err != nil {
+				return err
+			}
+			defer joinedNetns.Close()
+			joinedIP = net.ParseIP(joined.IP)
+		}
+		runOpts.hostname = ctx.String("hostname")
+		if runOpts.hostname == "" {
+			runOpts.hostname = makeShortId(runOpts.containerId)
+		}
+		for _, dns := range ctx.StringSlice("dns") {
+			if net.ParseIP(dns) == nil {
+				return fmt.Errorf("bad DNS server `%v`", dns)
+			}
+		}
+		runOpts.dns = ctx.StringSlice("dns")
+		runOpts.dnsSearch = ctx.StringSlice("dns-search")
+		for _, extraHost := range ctx.StringSlice("add-host") {
+			if err := parseExtraHost(extraHost); err != nil {
+				return err
+			}
+		}
+		runOpts.extraHosts = ctx.StringSlice("add-host")
+		for _, spec := range ctx.StringSlice("publish") {
+			mappings, err := parsePublish(spec)
+			if err != nil {
+				return fmt.Errorf("bad publish option: %w", err)
+			}
+			runOpts.ports = append(runOpts.ports, mappings...)
+		}
+		if len(runOpts.ports) > 0 {
+			if runOpts.network.Type != NetworkBridge {
+				return fmt.Errorf("publishing ports needs a bridge network")
+			}
+			if err := checkPortConflicts(runOpts.ports); err != nil {
+				return err
+			}
+		}
+		runOpts.devices = append(runOpts.devices, defaultDevices...)
+		for _, spec := range ctx.StringSlice("device") {
+			device, err := parseDevice(spec)
+			if err != nil {
+				return fmt.Errorf("bad device option: %w", err)
+			}
+			runOpts.devices = append(runOpts.devices, device)
+		}
 		if ctx.String("v") != "" {
 			runOpts.volumes = strings.Split(ctx.String("v"), ":")
 			if len(runOpts.volumes) != 2 {
 				return fmt.Errorf("bad volumes")
 			}
 		}
-
+		if _, err := os.Stat(makeContainerConfigPath(runOpts.containerName)); err == nil {
+			return fmt.Errorf("the container name `%v` is already in use", runOpts.containerName)
+		}
 		subsystemConfig := SubsystemConfig{
-			cpuShare:  ctx.Int("cpu-shares"),
-			memory:    ctx.String("m"),
-			cpuPeriod: ctx.Int("cpu-period"),
-			cpuQuota:  ctx.Int("cpu-quota"),
-			cpus:      ctx.Float64("cpus"),
-			cpuSet:    ctx.String("cpuset-cpus"),
+			CpuShare:       ctx.Int("cpu-shares"),
+			CpuPeriod:      ctx.Int("cpu-period"),
+			CpuQuota:       ctx.Int("cpu-quota"),
+			Cpus:           ctx.Float64("cpus"),
+			CpuSet:         ctx.String("cpuset-cpus"),
+			OomKillDisable: ctx.Bool("oom-kill-disable"),
+			PidsLimit:      ctx.Int64("pids-limit"),
+			BlkioWeight:    ctx.Int("blkio-weight"),
+			Devices:        append(append([]Device{}, defaultDeviceRules...), runOpts.devices...),
+		}
+		for _, opt := range []struct {
+			name  string
+			value *int64
+		}{
+			{"m", &subsystemConfig.Memory},
+			{"memory-swap", &subsystemConfig.MemorySwap},
+			{"memory-reservation", &subsystemConfig.MemoryReservation},
+			{"kernel-memory", &subsystemConfig.KernelMemory},
+		} {
+			if ctx.String(opt.name) == "" {
+				continue
+			}
+			if *opt.value, err = parseBytes(ctx.String(opt.name)); err != nil {
+				return fmt.Errorf("bad %v option: %w", opt.name, err)
+			}
+		}
+		for _, opt := range []struct {
+			name    string
+			isBytes bool
+			devices *[]ThrottleDevice
+		}{
+			{"device-read-bps", true, &subsystemConfig.BlkioDeviceReadBps},
+			{"device-write-bps", true, &subsystemConfig.BlkioDeviceWriteBps},
+			{"device-read-iops", false, &subsystemConfig.BlkioDeviceReadIOps},
+			{"device-write-iops", false, &subsystemConfig.BlkioDeviceWriteIOps},
+		} {
+			for _, value := range ctx.StringSlice(opt.name) {
+				device, err := parseThrottleDevice(value, opt.isBytes)
+				if err != nil {
+					return fmt.Errorf("bad %v option: %w", opt.name, err)
+				}
+				*opt.devices = append(*opt.devices, device)
+			}
+		}
+		if err := subsystemConfig.Validate(); err != nil {
+			return err
+		}
+
+		// A container with a terminal always runs under a shim, so that we
+		// can detach from the terminal and attach to it again.
+		if (runOpts.detach || runOpts.tty) && shimId == "" {
+			shim, err := startShim(runOpts)
+			if err != nil {
+				return err
+			}
+			if runOpts.detach {
+				if err := shim.WaitStarted(); err != nil {
+					return err
+				}
+				fmt.Println(runOpts.containerId)
+				return nil
+			}
+			// The shim doesn't start the container until we have
+			// attached, so that we don't miss any of its output.
+			if err := shim.WaitConsole(); err != nil {
+				return err
+			}
+			console, dialErr := DialConsole(runOpts.containerName)
+			if dialErr != nil {
+				// The shim may have failed in the meantime.
+				if err := shim.WaitStarted(); err != nil {
+					return err
+				}
+				return dialErr
+			}
+			defer console.Close()
+			if err := shim.WaitStarted(); err != nil {
+				return err
+			}
+			detached, err := attachConsole(console, runOpts.interactive, detachKeys)
+			if err != nil || detached {
+				return err
+			}
+			exitCode, err := waitContainer(runOpts.containerName)
+			if err != nil {
+				return err
+			}
+			if exitCode != 0 {
+				return cli.NewExitError("", exitCode)
+			}
+			return nil
 		}
-		log.Printf("runOpts=%v, subsystemConfig=%v", runOpts, subsystemConfig)
+
+		log.Printf("image=%v, command=%v, subsystemConfig=%v", runOpts.imageName, runOpts.command, subsystemConfig)
+
+		// Everything done to create the container is undone if a later
+		// step fails, until the container has started.
+		tx := &Transaction{BeforeStep: runStepHook}
+		defer func() {
+			if err != nil {
+				if err := tx.Rollback(); err != nil {
+					log.Printf("can't roll back container `%v`: %v", runOpts.containerName, err)
+				}
+			}
+		}()
 
 		initCmd, err := os.Readlink("/proc/self/exe")
 		if err != nil {
@@ -115,76 +446,398 @@ var runCommand = cli.Command{
 			return err
 		}
 		cmd := exec.Command(initCmd, "init")
-		cloneFlags := syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
-		if ctx.String("network") != "" && ctx.String("network") != "host" {
-			cloneFlags = cloneFlags | syscall.CLONE_NEWNET
-		}
+		// With --init, init stays as PID 1 and its environment is the one
+		// seen in the container, so it must not be ours.
+		cmd.Env = runOpts.env
 		cmd.SysProcAttr = &syscall.SysProcAttr{
-			Cloneflags:   uintptr(cloneFlags),
+			Cloneflags:   makeCloneFlags(runOpts.network),
 			Unshareflags: syscall.CLONE_NEWNS,
 		}
-		if runOpts.createTty {
-			cmd.Stdin = os.Stdin
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-		}
 		readPipe, writePipe, err := os.Pipe()
 		if err != nil {
 			log.Printf("Create pipe failed: %v", err)
 			return err
 		}
+		defer readPipe.Close()
+		defer writePipe.Close()
 		cmd.ExtraFiles = []*os.File{readPipe}
 		cmd.Dir = makeContainerMergedDir(runOpts.containerName)
-		if err := createContainerWorkspace(runOpts); err != nil {
+		info := &ContainerInfo{
+			Id:        runOpts.containerId,
+			Name:      runOpts.containerName,
+			Image:     runOpts.imageName,
+			Command:   runOpts.command,
+			Status:    StatusCreated,
+			CreatedAt: time.Now(),
+			Ports:     runOpts.ports,
+			CgroupId:  runOpts.containerId,
+			Resources: subsystemConfig,
+			Tty:       runOpts.tty,
+		}
+		info.CreatorPid = os.Getpid()
+		if info.CreatorStartTime, err = processStartTime(info.CreatorPid); err != nil {
 			return err
 		}
-		if err = cmd.Start(); err != nil {
-			log.Printf("can't start command: %v, %v", cmd, err)
+		info.NetworkMode = runOpts.network.String()
+		if runOpts.network.Type == NetworkBridge {
+			info.Network = runOpts.network.Name
+		}
+		if len(runOpts.volumes) == 2 {
+			_, err := os.Stat(runOpts.volumes[0])
+			info.Volumes = []Volume{{
+				Source:      runOpts.volumes[0],
+				Destination: runOpts.volumes[1],
+				Created:     os.IsNotExist(err),
+			}}
+		}
+		err = tx.Do("create workspace", func() error {
+			if err := createContainerWorkspace(runOpts); err != nil {
+				return err
+			}
+			return info.Save()
+		}, func() error {
+			return removeContainerWorkspace(info)
+		})
+		if err != nil {
 			return err
 		}
 
+		// Log the output of the container, and also show it unless detached.
+		jsonLog, err := OpenJsonLog(runOpts.containerName)
+		if err != nil {
+			return err
+		}
+		defer jsonLog.Close()
+		stdout := jsonLog.Writer("stdout")
+		stderr := jsonLog.Writer("stderr")
+		if shimId == "" {
+			stdout = io.MultiWriter(os.Stdout, stdout)
+			stderr = io.MultiWriter(os.Stderr, stderr)
+		}
+		var pty, ptySlave *os.File
+		if runOpts.tty {
+			// The pty becomes the controlling terminal of init, and all
+			// that's written to it is logged as stdout.
+			if pty, ptySlave, err = openPty(); err != nil {
+				return err
+			}
+			defer pty.Close()
+			cmd.Stdin = ptySlave
+			cmd.Stdout = ptySlave
+			cmd.Stderr = ptySlave
+			cmd.SysProcAttr.Setsid = true
+			cmd.SysProcAttr.Setctty = true
+			cmd.SysProcAttr.Ctty = 0
+		} else {
+			cmd.Stdout = stdout
+			cmd.Stderr = stderr
+			if runOpts.interactive {
+				cmd.Stdin = os.Stdin
+			}
+		}
+
+		// The cgroup is set up before init starts, so that init is killed
+		// before the cgroup is removed on rollback.
 		cgroup := NewCgroup(runOpts.containerId)
-		if err := cgroup.Set(subsystemConfig); err != nil {
+		err = tx.Do("set up cgroup", func() error {
+			return cgroup.Set(subsystemConfig)
+		}, cgroup.Destroy)
+		if err != nil {
+			return err
+		}
+
+		start := cmd.Start
+		if runOpts.network.Type == NetworkContainer {
+			start = func() error {
+				return startInNetns(cmd, joinedNetns)
+			}
+		}
+		err = tx.Do("start init", start, func() error {
+			if cmd.Process == nil {
+				return nil
+			}
+			if err := cmd.Process.Kill(); err != nil && err != os.ErrProcessDone {
+				return err
+			}
+			cmd.Wait()
+			return nil
+		})
+		if err != nil {
+			log.Printf("can't start command: %v, %v", cmd, err)
 			return err
 		}
-		if err := cgroup.Apply(cmd.Process.Pid); err != nil {
+		var console *ConsoleServer
+		var ptyDone chan struct{}
+		if pty != nil {
+			ptySlave.Close()
+			if console, err = NewConsoleServer(runOpts.containerName, pty); err != nil {
+				return err
+			}
+			defer console.Close()
+			ptyDone = make(chan struct{})
+			go func() {
+				io.Copy(io.MultiWriter(stdout, console), pty)
+				close(ptyDone)
+			}()
+		}
+
+		err = tx.Do("apply cgroup", func() error {
+			return cgroup.Apply(cmd.Process.Pid)
+		}, nil)
+		if err != nil {
 			return err
 		}
-		defer cgroup.Destroy()
 
 		container := Container{
 			id: runOpts.containerId,
 			pid: cmd.Process.Pid,
+			ports: runOpts.ports,
 		}
-		network := ctx.String("net")
-		var need_net = false
-		if network != "" && network != "host" {
-			need_net = true
-			if err := Connect(network, &container); err != nil {
+		switch network := runOpts.network.Name; runOpts.network.Type {
+		case NetworkBridge:
+			err = tx.Do("connect network", func() error {
+				if err := Connect(network, &container); err != nil {
+					return err
+				}
+				return writeHostsFile(runOpts, container.ip)
+			}, func() error {
+				// Connect releases what it has set up when it fails.
+				if container.ip == nil {
+					return nil
+				}
+				return Disconnect(network, container)
+			})
+			if err != nil {
+				return err
+			}
+		case NetworkContainer:
+			// The container is reachable at the address of the container
+			// whose network it shares.
+			err = tx.Do("write hosts file", func() error {
+				return writeHostsFile(runOpts, joinedIP)
+			}, nil)
+			if err != nil {
 				return err
 			}
 		}
 
-		log.Printf("sending command: %v", runOpts.command)
-		writePipe.WriteString(runOpts.command)
-		writePipe.Close()
+		info.Pid = container.pid
+		if info.PidStartTime, err = processStartTime(info.Pid); err != nil {
+			return err
+		}
+		info.Status = StatusRunning
+		info.StartedAt = time.Now()
+		if container.ip != nil {
+			info.IP = container.ip.String()
+		}
+		if err := tx.Do("save state", info.Save, nil); err != nil {
+			return err
+		}
 
-		if runOpts.createTty {
-			cmd.Wait()
-			if need_net {
-				if err := Disconnect(network, container); err != nil {
-					return err
-				}
+		// Don't let the output of a foreground container go out before
+		// `mydocker run` has attached.
+		if console != nil && !runOpts.detach {
+			if ready != nil {
+				ready.WriteString(ShimConsoleMessage)
 			}
-			if err := cleanContainerWorkspace(runOpts); err != nil {
+			console.WaitClient(10 * time.Second)
+		}
+
+		initMsg := InitMessage{
+			Version:  InitMessageVersion,
+			Args:     runOpts.command,
+			Env:      runOpts.env,
+			Cwd:      runOpts.workingDir,
+			Hostname: runOpts.hostname,
+			User:     runOpts.user,
+			Init:     runOpts.init,
+			Devices:  runOpts.devices,
+			// Connect brings the loopback up with a bridge network.
+			SetUpLoopback: runOpts.network.Type == NetworkNone,
+		}
+		log.Printf("sending init message: args=%v", initMsg.Args)
+		err = tx.Do("send init message", func() error {
+			if err := json.NewEncoder(writePipe).Encode(initMsg); err != nil {
 				return err
 			}
+			return writePipe.Close()
+		}, nil)
+		if err != nil {
+			return err
+		}
+		tx.Commit()
+		if ready != nil {
+			ready.WriteString(ShimReadyMessage)
+			ready.Close()
+			ready = nil
 		}
 
+		cmd.Wait()
+		if ptyDone != nil {
+			<-ptyDone
+			console.Close()
+		}
+		exitCode := 0
+		if cmd.ProcessState != nil {
+			exitCode = exitStatus(cmd.ProcessState)
+		}
+		if err := finishContainer(info.Name, exitCode); err != nil {
+			log.Printf("can't clean up container `%v`: %v", info.Name, err)
+		}
+		// Exit with the exit status of the container.
+		if exitCode != 0 {
+			return cli.NewExitError("", exitCode)
+		}
 		return nil
 	},
 }
 
+// runStepHook is called before each step of creating a container, and
+// fails the step with the error it returns. Failures can be injected
+// through it.
+var runStepHook func(step string) error
+
+// The fd on which the shim reports to `mydocker run` that the container has
+// started, by writing ShimReadyMessage, or why it failed to start. A shim
+// waiting for `mydocker run` to attach to the console of the container
+// writes ShimConsoleMessage before.
+const (
+	ShimReadyPipe      = uintptr(3)
+	ShimReadyMessage   = "\x00"
+	ShimConsoleMessage = "\x01"
+)
+
+// Shim is a shim started by startShim.
+type Shim struct {
+	cmd   *exec.Cmd
+	ready *os.File
+	// What has been read from the ready pipe and not yet made sense of.
+	msg []byte
+}
+
+// startShim runs the container in the background under a shim, which is this
+// same program re-executed with the original arguments of `mydocker run`. The
+// shim starts the container, waits for it and cleans up after it exits.
+func startShim(runOpts RunOptions) (*Shim, error) {
+	self, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return nil, err
+	}
+	readPipe, writePipe, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	args := append([]string{"run", "--shim-id", runOpts.containerId}, os.Args[2:]...)
+	cmd := exec.Command(self, args...)
+	cmd.ExtraFiles = []*os.File{writePipe}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+	err = cmd.Start()
+	writePipe.Close()
+	if err != nil {
+		readPipe.Close()
+		return nil, err
+	}
+	return &Shim{cmd: cmd, ready: readPipe}, nil
+}
+
+// WaitConsole returns once the console of the container is up for us to
+// attach, or with the error of the shim if it fails before.
+func (s *Shim) WaitConsole() error {
+	buf := make([]byte, 1)
+	n, _ := s.ready.Read(buf)
+	if n == 1 && string(buf) == ShimConsoleMessage {
+		return nil
+	}
+	s.msg = buf[:n]
+	return s.WaitStarted()
+}
+
+// WaitStarted returns once the shim has started the container, or with the
+// error of the shim if it fails to.
+func (s *Shim) WaitStarted() error {
+	defer s.ready.Close()
+	rest, err := ioutil.ReadAll(s.ready)
+	if err != nil {
+		return err
+	}
+	msg := string(append(s.msg, rest...))
+	if msg == ShimReadyMessage {
+		return s.cmd.Process.Release()
+	}
+	s.cmd.Wait()
+	if len(msg) == 0 {
+		return fmt.Errorf("the shim exited without starting the container")
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+func exitStatus(state *os.ProcessState) int {
+	status := state.Sys().(syscall.WaitStatus)
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
+
+// finishContainer records the exit of a container and releases everything it
+// holds. It is called by whoever notices the exit first, the process waiting
+// for the container or `mydocker stop`, and does nothing if the container has
+// been finished already.
+func finishContainer(name string, exitCode int) error {
+	lock, err := lockContainer(name)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	info, err := NewContainerInfo(name)
+	if err != nil {
+		return err
+	}
+	if info.Status == StatusExited {
+		return nil
+	}
+	info.Status = StatusExited
+	info.FinishedAt = time.Now()
+	info.ExitCode = exitCode
+	teardownErr := teardownContainer(info)
+	if err := info.Save(); err != nil {
+		return err
+	}
+	return teardownErr
+}
+
+// teardownContainer releases everything held by a container that has exited.
+// It goes on after a failed step so that one missing resource does not leak
+// all the others, and returns the first error. The IP address is cleared from
+// info once released so that tearing down again is harmless.
+func teardownContainer(info *ContainerInfo) error {
+	var firstErr error
+	if info.Network != "" && info.Network != "host" && info.IP != "" {
+		container := Container{
+			id: info.Id,
+			pid: info.Pid,
+			ip: net.ParseIP(info.IP),
+			ports: info.Ports,
+		}
+		if err := Disconnect(info.Network, container); err != nil {
+			firstErr = err
+		} else {
+			info.IP = ""
+		}
+	}
+	if err := cleanContainerWorkspace(info); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	if err := NewCgroup(info.CgroupId).Destroy(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
 func createContainerWorkspace(opts RunOptions) error {
 	imagePath := makeImagePath(opts.imageName)
 	_, err := os.Stat(imagePath)
@@ -222,16 +875,58 @@ func createContainerWorkspace(opts RunOptions) error {
 		}
 	}
 
-	return nil
+	return mountEtcFiles(opts)
 }
 
-func cleanContainerWorkspace(opts RunOptions) error {
-	if err := syscall.Unmount(makeContainerMergedDir(opts.containerName), syscall.MNT_DETACH); err != nil {
+// removeContainerWorkspace removes all that createContainerWorkspace
+// created, including the container directory and the volume directories it
+// created, for a container that failed to be created.
+func removeContainerWorkspace(info *ContainerInfo) error {
+	// Never remove the directory with a mount still inside.
+	if err := cleanContainerWorkspace(info); err != nil {
 		return err
 	}
-	if err := os.RemoveAll(makeContainerDir(opts.containerName)); err != nil {
+	for _, volume := range info.Volumes {
+		if volume.Created {
+			if err := os.RemoveAll(volume.Source); err != nil {
+				return err
+			}
+		}
+	}
+	return os.RemoveAll(makeContainerDir(info.Name))
+}
+
+func cleanContainerWorkspace(info *ContainerInfo) error {
+	mergedDir := makeContainerMergedDir(info.Name)
+	if err := unmountEtcFiles(info.Name); err != nil {
+		return err
+	}
+	for _, volume := range info.Volumes {
+		if err := unmount(path.Join(mergedDir, volume.Destination)); err != nil {
+			return err
+		}
+	}
+	if err := unmount(mergedDir); err != nil {
 		return err
 	}
+	// Keep the container directory itself, which holds the container state.
+	for _, dir := range []string{
+		mergedDir,
+		makeContainerUpperDir(info.Name),
+		makeContainerWorkDir(info.Name),
+	} {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// unmount lazily unmounts target, ignoring targets that are not mounted.
+func unmount(target string) error {
+	err := syscall.Unmount(target, syscall.MNT_DETACH)
+	if err == syscall.EINVAL || err == syscall.ENOENT {
+		return nil
+	}
+	return err
+}
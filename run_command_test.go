@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/vishvananda/netlink"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// The container runs this test binary as its init, which must then act as
+// mydocker.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestRunRollsBackFailedStep(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating containers needs root")
+	}
+
+	// An empty image is enough, since the command never runs.
+	image := fmt.Sprintf("rollback-test-%d", os.Getpid())
+	if err := os.MkdirAll(path.Join(makeImagePath(image), "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(makeImagePath(image))
+
+	// Don't touch the firewall of the host; connecting a container without
+	// published ports doesn't need it anyway.
+	binDir := t.TempDir()
+	if err := ioutil.WriteFile(path.Join(binDir, "iptables"), []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+":"+os.Getenv("PATH"))
+
+	network := fmt.Sprintf("mdrb%d", os.Getpid()%100000)
+	const subnet = "10.251.0.0/24"
+	if err := CreateNetwork("bridge", subnet, network); err != nil {
+		t.Fatalf("can't create network: %v", err)
+	}
+	defer DeleteNetwork(network)
+	joined := startJoinedContainer(t)
+
+	defer func() { runStepHook = nil }()
+	for _, mode := range []string{network, "container:" + joined} {
+		name := fmt.Sprintf("rollback-test-%d", os.Getpid())
+		args := []string{"mydocker", "run", "--name", name, "--net", mode, "-m", "64m", image, "true"}
+		for _, step := range recordRunSteps(t, args, name) {
+			t.Run(mode+"/"+step, func(t *testing.T) {
+				cgroupsBefore := listCgroups(t)
+				ipsBefore := readSubnetAllocation(t, subnet)
+				linksBefore := listLinks(t)
+
+				reached := false
+				runStepHook = func(s string) error {
+					if s == step {
+						reached = true
+						return errors.New("injected failure")
+					}
+					return nil
+				}
+				err := newApp().Run(args)
+				if !reached {
+					t.Fatalf("the step wasn't reached: %v", err)
+				}
+				if err == nil || !strings.Contains(err.Error(), "injected failure") {
+					t.Fatalf("got error %v, want the injected failure", err)
+				}
+
+				if _, err := os.Stat(makeContainerDir(name)); !os.IsNotExist(err) {
+					os.RemoveAll(makeContainerDir(name))
+					t.Errorf("the container directory is left: %v", err)
+				}
+				for cgroup := range listCgroups(t) {
+					if !cgroupsBefore[cgroup] {
+						t.Errorf("the cgroup %v is left", cgroup)
+					}
+				}
+				if ips := readSubnetAllocation(t, subnet); ips != ipsBefore {
+					t.Errorf("the allocation of %v went from %v to %v", subnet, ipsBefore, ips)
+				}
+				for link := range listLinks(t) {
+					if !linksBefore[link] {
+						t.Errorf("the link %v is left", link)
+					}
+				}
+				for _, pid := range listInitChildren(t) {
+					t.Errorf("the init process %d is left", pid)
+				}
+				for _, mountPoint := range listMountsUnder(t, makeContainerDir(name)) {
+					t.Errorf("the mount on %v is left", mountPoint)
+				}
+			})
+		}
+	}
+}
+
+// recordRunSteps runs a container to completion and returns the steps of
+// creating it, in order, then removes it.
+func recordRunSteps(t *testing.T, args []string, name string) []string {
+	var steps []string
+	runStepHook = func(step string) error {
+		steps = append(steps, step)
+		return nil
+	}
+	defer func() { runStepHook = nil }()
+	// The command is not in the image, and run exits with its exit status.
+	exiter := cli.OsExiter
+	cli.OsExiter = func(int) {}
+	defer func() { cli.OsExiter = exiter }()
+	if err := newApp().Run(args); err != nil {
+		if _, ok := err.(cli.ExitCoder); !ok {
+			t.Fatalf("can't run a container: %v", err)
+		}
+	}
+	if err := removeContainer(name, false, false); err != nil {
+		t.Fatalf("can't remove the container: %v", err)
+	}
+	if len(steps) == 0 {
+		t.Fatalf("no step was run")
+	}
+	return steps
+}
+
+// startJoinedContainer makes up a running container with a network
+// namespace of its own, whose network a container can join, and returns its
+// name.
+func startJoinedContainer(t *testing.T) string {
+	cmd := exec.Command("sleep", "1000")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNET}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	startTime, err := processStartTime(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &ContainerInfo{
+		Id:           makeContainerId(),
+		Name:         fmt.Sprintf("rollback-joined-%d", os.Getpid()),
+		Pid:          cmd.Process.Pid,
+		PidStartTime: startTime,
+		Status:       StatusRunning,
+		IP:           "10.251.0.99",
+	}
+	if err := info.Save(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(makeContainerDir(info.Name)) })
+	return info.Name
+}
+
+// listCgroups returns the cgroups right below the roots of the subsystems.
+func listCgroups(t *testing.T) map[string]bool {
+	cgroups := make(map[string]bool)
+	for _, subsys := range subsystems {
+		root, err := NewCgroup("").Path(subsys.Name())
+		if errors.Is(err, errSubsystemNotMounted) {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := ioutil.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				cgroups[path.Join(root, entry.Name())] = true
+			}
+		}
+	}
+	return cgroups
+}
+
+func readSubnetAllocation(t *testing.T, subnet string) string {
+	subnets, err := loadSubnets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return subnets[subnet]
+}
+
+func listLinks(t *testing.T) map[string]bool {
+	links, err := netlink.LinkList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, link := range links {
+		names[link.Attrs().Name] = true
+	}
+	return names
+}
+
+// listInitChildren returns the children of the test process running as the
+// init of a container, counting the zombies too, whose command line is gone.
+func listInitChildren(t *testing.T) []int {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// The process may be gone already.
+		stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			continue
+		}
+		// The fields after the command name, which may hold spaces, start
+		// with the state and the parent pid.
+		fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+		if len(fields) < 2 || fields[1] != strconv.Itoa(os.Getpid()) {
+			continue
+		}
+		if fields[0] == "Z" {
+			pids = append(pids, pid)
+			continue
+		}
+		cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+		if err != nil {
+			continue
+		}
+		if args := strings.Split(string(cmdline), "\x00"); len(args) > 1 && args[1] == "init" {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// listMountsUnder returns the mount points in the directory or below. The
+// directory may be gone, but its parent must exist.
+func listMountsUnder(t *testing.T, dir string) []string {
+	// The mount points are the real paths, and /var/run is often a link to
+	// /run.
+	parent, err := filepath.EvalSymlinks(path.Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir = path.Join(parent, path.Base(dir))
+	mounts, err := readMountInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mountPoints []string
+	for _, m := range mounts {
+		if m.mountPoint == dir || strings.HasPrefix(m.mountPoint, dir+"/") {
+			mountPoints = append(mountPoints, m.mountPoint)
+		}
+	}
+	return mountPoints
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/vishvananda/netlink"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var statsCommand = cli.Command{
+	Name:      "stats",
+	Usage:     "display a live stream of container(s) resource usage statistics",
+	UsageText: `mydocker stats [OPTIONS] [CONTAINER...]`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-stream",
+			Usage: "disable streaming stats and only pull the first result",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Value: "table",
+			Usage: "output format: table or json",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		format := ctx.String("format")
+		if format != "table" && format != "json" {
+			return fmt.Errorf("unknown format `%v`; expecting table or json", format)
+		}
+		names := []string(ctx.Args())
+		for _, name := range names {
+			info, err := FindContainer(name)
+			if err != nil {
+				return err
+			}
+			if !info.IsRunning() {
+				return fmt.Errorf("the container `%v` is not running", name)
+			}
+		}
+
+		// The CPU usage is computed from two samples, so nothing is shown
+		// before the second one.
+		const interval = time.Second
+		last := make(map[string]*statsSample)
+		for i := 0; ; i++ {
+			containers, err := listStatsContainers(names)
+			if err != nil {
+				return err
+			}
+			var stats []ContainerStats
+			samples := make(map[string]*statsSample)
+			for _, info := range containers {
+				sample, err := readStatsSample(info)
+				if err != nil {
+					if !info.ProcessExists() {
+						// The container has just stopped.
+						continue
+					}
+					return fmt.Errorf("can't read the stats of the container `%v`: %w", info.Name, err)
+				}
+				samples[info.Id] = sample
+				if prev, ok := last[info.Id]; ok {
+					stats = append(stats, makeContainerStats(info, prev, sample))
+				}
+			}
+			last = samples
+
+			if i > 0 {
+				if err := printStats(stats, format, !ctx.Bool("no-stream")); err != nil {
+					return err
+				}
+				if ctx.Bool("no-stream") {
+					return nil
+				}
+			}
+			time.Sleep(interval)
+		}
+	},
+}
+
+// ContainerStats is the resource usage of a container over an interval.
+type ContainerStats struct {
+	Id            string
+	Name          string
+	CpuPercent    float64
+	MemoryUsage   uint64
+	MemoryLimit   uint64
+	MemoryPercent float64
+	// The bytes received and sent by the container.
+	NetworkRx  uint64
+	NetworkTx  uint64
+	BlockRead  uint64
+	BlockWrite uint64
+	Pids       int64
+}
+
+type statsSample struct {
+	cgroup    *CgroupStats
+	networkRx uint64
+	networkTx uint64
+	time      time.Time
+}
+
+// listStatsContainers resolves the containers again on every sample, so
+// that the containers started meanwhile are shown too when none is named.
+func listStatsContainers(names []string) ([]ContainerInfo, error) {
+	var containers []ContainerInfo
+	if len(names) == 0 {
+		all, err := ListContainers()
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range all {
+			if info.IsRunning() {
+				containers = append(containers, info)
+			}
+		}
+		return containers, nil
+	}
+	for _, name := range names {
+		info, err := FindContainer(name)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsRunning() {
+			containers = append(containers, *info)
+		}
+	}
+	return containers, nil
+}
+
+func readStatsSample(info ContainerInfo) (*statsSample, error) {
+	cgroupStats, err := NewCgroup(info.CgroupId).Stats()
+	if err != nil {
+		return nil, err
+	}
+	sample := &statsSample{cgroup: cgroupStats, time: time.Now()}
+	if info.Network != "" && info.Network != "host" {
+		veth, err := netlink.LinkByName(makeVethName(info.Id))
+		if err != nil {
+			return nil, err
+		}
+		// What the host end of the veth pair sends, the container receives.
+		if statistics := veth.Attrs().Statistics; statistics != nil {
+			sample.networkRx = statistics.TxBytes
+			sample.networkTx = statistics.RxBytes
+		}
+	}
+	return sample, nil
+}
+
+func makeContainerStats(info ContainerInfo, prev, cur *statsSample) ContainerStats {
+	stats := ContainerStats{
+		Id:          info.Id,
+		Name:        info.Name,
+		MemoryUsage: cur.cgroup.MemoryUsage,
+		MemoryLimit: cur.cgroup.MemoryLimit,
+		NetworkRx:   cur.networkRx,
+		NetworkTx:   cur.networkTx,
+		BlockRead:   cur.cgroup.BlockRead,
+		BlockWrite:  cur.cgroup.BlockWrite,
+		Pids:        cur.cgroup.Pids,
+	}
+	// As a share of one CPU, so a container using two CPUs shows 200%.
+	if elapsed := cur.time.Sub(prev.time); elapsed > 0 && cur.cgroup.CpuUsage > prev.cgroup.CpuUsage {
+		stats.CpuPercent = float64(cur.cgroup.CpuUsage-prev.cgroup.CpuUsage) / float64(elapsed.Nanoseconds()) * 100
+	}
+	if stats.MemoryLimit != 0 {
+		stats.MemoryPercent = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100
+	}
+	return stats
+}
+
+func printStats(stats []ContainerStats, format string, refresh bool) error {
+	if format == "json" {
+		return json.NewEncoder(os.Stdout).Encode(stats)
+	}
+
+	if refresh {
+		// Clear the screen and move the cursor home.
+		fmt.Print("\033[2J\033[H")
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(writer, "CONTAINER ID\tNAME\tCPU %\tMEM USAGE / LIMIT\tMEM %\tNET I/O\tBLOCK I/O\tPIDS\n")
+	for _, s := range stats {
+		fmt.Fprintf(writer, "%s\t%s\t%.2f%%\t%s / %s\t%.2f%%\t%s / %s\t%s / %s\t%d\n",
+			makeShortId(s.Id),
+			s.Name,
+			s.CpuPercent,
+			humanSize(s.MemoryUsage), humanSize(s.MemoryLimit),
+			s.MemoryPercent,
+			humanSize(s.NetworkRx), humanSize(s.NetworkTx),
+			humanSize(s.BlockRead), humanSize(s.BlockWrite),
+			s.Pids)
+	}
+	return writer.Flush()
+}
+
+// humanSize formats a size in bytes with binary units, e.g. 1.5MiB.
+func humanSize(size uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.4g%s", value, units[i])
+}
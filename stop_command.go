@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"log"
+	"syscall"
+	"time"
+)
+
+var stopCommand = cli.Command{
+	Name:      "stop",
+	Usage:     "stop one or more running containers",
+	UsageText: `mydocker stop [OPTIONS] CONTAINER...`,
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t",
+			Value: 10,
+			Usage: "seconds to wait for stop before killing it",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) == 0 {
+			return fmt.Errorf("missing container name")
+		}
+		timeout := time.Duration(ctx.Int("t")) * time.Second
+		for _, name := range ctx.Args() {
+			if err := stopContainer(name, timeout); err != nil {
+				return err
+			}
+			fmt.Println(name)
+		}
+		return nil
+	},
+}
+
+// stopContainer sends SIGTERM to the container and SIGKILL if it's still
+// running after the timeout.
+func stopContainer(nameOrId string, timeout time.Duration) error {
+	info, err := FindContainer(nameOrId)
+	if err != nil {
+		return err
+	}
+	if !info.IsRunning() {
+		if info.Status == StatusRunning || info.Status == StatusPaused {
+			// The container has died without being cleaned up.
+			return finishContainer(info.Name, 0)
+		}
+		return nil
+	}
+	if info.Status == StatusPaused {
+		return fmt.Errorf("the container `%v` is paused; unpause it before stopping it", nameOrId)
+	}
+
+	exitCode := 128 + int(syscall.SIGTERM)
+	if err := info.Signal(syscall.SIGTERM); err != nil {
+		return err
+	}
+	if !info.WaitExit(timeout) {
+		log.Printf("the container `%v` did not stop in %v; killing it", info.Name, timeout)
+		exitCode = 128 + int(syscall.SIGKILL)
+		if err := info.Signal(syscall.SIGKILL); err != nil {
+			return err
+		}
+		info.WaitExit(timeout)
+	}
+	return reapContainer(info.Name, exitCode)
+}
+
+// reapContainer gives the process waiting for the container a chance to
+// record its exit status, and finishes the container itself when there is no
+// such process any more.
+func reapContainer(name string, exitCode int) error {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		info, err := NewContainerInfo(name)
+		if err != nil {
+			return err
+		}
+		if info.Status == StatusExited {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return finishContainer(name, exitCode)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Transaction runs the steps of creating something, and undoes the steps
+// done so far when one of them fails, so that a failure leaks nothing.
+type Transaction struct {
+	// BeforeStep, if set, is called with the name of each step before it
+	// is done; an error fails the step. It lets failures be injected.
+	BeforeStep func(name string) error
+
+	undos []undoStep
+}
+
+type undoStep struct {
+	name string
+	undo func() error
+}
+
+// Do does a step. The undo of the step is kept even when the step fails,
+// since it may have been partly done, so undo must cope with a step done in
+// part or not at all. undo may be nil.
+func (t *Transaction) Do(name string, do func() error, undo func() error) error {
+	if undo != nil {
+		t.undos = append(t.undos, undoStep{name: name, undo: undo})
+	}
+	if t.BeforeStep != nil {
+		if err := t.BeforeStep(name); err != nil {
+			return fmt.Errorf("%v: %w", name, err)
+		}
+	}
+	if err := do(); err != nil {
+		return fmt.Errorf("%v: %w", name, err)
+	}
+	return nil
+}
+
+// Rollback undoes the steps in reverse order. It goes on after a failed
+// undo so that one leaked resource does not leak the others too, and returns
+// the first error.
+func (t *Transaction) Rollback() error {
+	var firstErr error
+	for i := len(t.undos) - 1; i >= 0; i-- {
+		if err := t.undos[i].undo(); err != nil {
+			log.Printf("can't undo %v: %v", t.undos[i].name, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("undo %v: %w", t.undos[i].name, err)
+			}
+		}
+	}
+	t.undos = nil
+	return firstErr
+}
+
+// Commit makes the steps done final, so that Rollback does nothing.
+func (t *Transaction) Commit() {
+	t.undos = nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// recordStep returns a step which records its name in done.
+func recordStep(done *[]string, name string, err error) func() error {
+	return func() error {
+		*done = append(*done, name)
+		return err
+	}
+}
+
+func TestTransactionRollbackUndoesInReverseOrder(t *testing.T) {
+	var done []string
+	tx := &Transaction{}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := tx.Do(name, recordStep(&done, name, nil), recordStep(&done, "undo "+name, nil)); err != nil {
+			t.Fatalf("step %v failed: %v", name, err)
+		}
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	want := []string{"a", "b", "c", "undo c", "undo b", "undo a"}
+	if !reflect.DeepEqual(done, want) {
+		t.Errorf("got %v, want %v", done, want)
+	}
+}
+
+func TestTransactionUndoesFailedStep(t *testing.T) {
+	var done []string
+	tx := &Transaction{}
+	tx.Do("a", recordStep(&done, "a", nil), recordStep(&done, "undo a", nil))
+	err := tx.Do("b", recordStep(&done, "b", errors.New("boom")), recordStep(&done, "undo b", nil))
+	if err == nil || err.Error() != "b: boom" {
+		t.Fatalf("got error %v, want b: boom", err)
+	}
+	tx.Rollback()
+	want := []string{"a", "b", "undo b", "undo a"}
+	if !reflect.DeepEqual(done, want) {
+		t.Errorf("got %v, want %v", done, want)
+	}
+}
+
+func TestTransactionUndoesStepFailedBeforeIt(t *testing.T) {
+	var done []string
+	tx := &Transaction{
+		BeforeStep: func(name string) error {
+			if name == "b" {
+				return errors.New("injected")
+			}
+			return nil
+		},
+	}
+	tx.Do("a", recordStep(&done, "a", nil), recordStep(&done, "undo a", nil))
+	if err := tx.Do("b", recordStep(&done, "b", nil), recordStep(&done, "undo b", nil)); err == nil {
+		t.Fatalf("step b didn't fail")
+	}
+	tx.Rollback()
+	want := []string{"a", "undo b", "undo a"}
+	if !reflect.DeepEqual(done, want) {
+		t.Errorf("got %v, want %v", done, want)
+	}
+}
+
+func TestTransactionRollbackGoesOnAfterFailedUndo(t *testing.T) {
+	var done []string
+	tx := &Transaction{}
+	tx.Do("a", recordStep(&done, "a", nil), recordStep(&done, "undo a", nil))
+	tx.Do("b", recordStep(&done, "b", nil), recordStep(&done, "undo b", errors.New("boom")))
+	tx.Do("c", recordStep(&done, "c", nil), nil)
+	if err := tx.Rollback(); err == nil || err.Error() != "undo b: boom" {
+		t.Errorf("got error %v, want undo b: boom", err)
+	}
+	want := []string{"a", "b", "c", "undo b", "undo a"}
+	if !reflect.DeepEqual(done, want) {
+		t.Errorf("got %v, want %v", done, want)
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	var done []string
+	tx := &Transaction{}
+	tx.Do("a", recordStep(&done, "a", nil), recordStep(&done, "undo a", nil))
+	tx.Commit()
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	want := []string{"a"}
+	if !reflect.DeepEqual(done, want) {
+		t.Errorf("got %v, want %v", done, want)
+	}
+}
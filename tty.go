@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func ioctl(fd uintptr, request uintptr, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// openPty allocates a pseudo-terminal and returns its master and slave.
+func openPty() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var n uint32
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	var unlock int32
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+func isTerminal(fd uintptr) bool {
+	var termios syscall.Termios
+	return ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&termios))) == nil
+}
+
+// makeRaw puts the terminal in raw mode like cfmakeraw(3), and returns the
+// previous state for restoreTerminal.
+func makeRaw(fd uintptr) (*syscall.Termios, error) {
+	var oldState syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&oldState))); err != nil {
+		return nil, err
+	}
+	newState := oldState
+	newState.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	newState.Oflag &^= syscall.OPOST
+	newState.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	newState.Cflag &^= syscall.CSIZE | syscall.PARENB
+	newState.Cflag |= syscall.CS8
+	newState.Cc[syscall.VMIN] = 1
+	newState.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&newState))); err != nil {
+		return nil, err
+	}
+	return &oldState, nil
+}
+
+func restoreTerminal(fd uintptr, state *syscall.Termios) error {
+	return ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(state)))
+}
+
+type Winsize struct {
+	Rows   uint16
+	Cols   uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+func getWinsize(fd uintptr) (*Winsize, error) {
+	ws := &Winsize{}
+	if err := ioctl(fd, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(ws))); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+func setWinsize(fd uintptr, ws *Winsize) error {
+	return ioctl(fd, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(ws)))
+}
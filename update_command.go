@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+)
+
+var updateCommand = cli.Command{
+	Name:      "update",
+	Usage:     "update configuration of one or more containers",
+	UsageText: `mydocker update [OPTIONS] CONTAINER...`,
+	Flags: []cli.Flag{
+		cli.Float64Flag{
+			Name:  "cpus",
+			Usage: "number of CPUs",
+		},
+		cli.IntFlag{
+			Name:  "cpu-shares",
+			Usage: "CPU shares (relative weight)",
+		},
+		cli.StringFlag{
+			Name:  "cpuset-cpus",
+			Usage: "CPUs in which to allow execution (0-3, 0,1)",
+		},
+		cli.StringFlag{
+			Name:  "m",
+			Usage: "memory limit",
+		},
+		cli.StringFlag{
+			Name:  "memory-swap",
+			Usage: "swap limit equal to memory plus swap: -1 to enable unlimited swap",
+		},
+		cli.Int64Flag{
+			Name:  "pids-limit",
+			Usage: "tune container pids limit (set 0 or -1 for unlimited)",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) == 0 {
+			return fmt.Errorf("missing container name")
+		}
+		if ctx.NumFlags() == 0 {
+			return fmt.Errorf("you must provide one or more flags when using this command")
+		}
+		for _, name := range ctx.Args() {
+			if err := updateContainer(ctx, name); err != nil {
+				return err
+			}
+			fmt.Println(name)
+		}
+		return nil
+	},
+}
+
+// updateContainer sets the resources given by the flags on the cgroup of a
+// running container, and records them in its state.
+func updateContainer(ctx *cli.Context, nameOrId string) error {
+	info, err := FindContainer(nameOrId)
+	if err != nil {
+		return err
+	}
+	lock, err := lockContainer(info.Name)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if info, err = NewContainerInfo(info.Name); err != nil {
+		return err
+	}
+	if !info.IsRunning() {
+		return fmt.Errorf("the container `%v` is not running", nameOrId)
+	}
+
+	config := info.Resources
+	// A limit set to 0 is cleared, which the cgroup needs written as -1 as
+	// 0 means not set.
+	if ctx.IsSet("cpus") {
+		config.Cpus = ctx.Float64("cpus")
+		if config.Cpus == 0 {
+			config.CpuQuota = -1
+		}
+	}
+	if ctx.IsSet("cpu-shares") {
+		config.CpuShare = ctx.Int("cpu-shares")
+	}
+	if ctx.IsSet("cpuset-cpus") {
+		config.CpuSet = ctx.String("cpuset-cpus")
+	}
+	if ctx.IsSet("pids-limit") {
+		config.PidsLimit = ctx.Int64("pids-limit")
+		if config.PidsLimit == 0 {
+			config.PidsLimit = -1
+		}
+	}
+	cgroup := NewCgroup(info.CgroupId)
+	if ctx.IsSet("m") {
+		if config.Memory, err = parseBytes(ctx.String("m")); err != nil {
+			return fmt.Errorf("bad m option: %w", err)
+		}
+		// The kernel fails to lower the limit below what it can't reclaim.
+		usage, err := cgroup.MemoryUsage()
+		if err != nil {
+			return err
+		}
+		if config.Memory > 0 && uint64(config.Memory) < usage {
+			return fmt.Errorf("the memory limit %v is below the current usage %v of the container `%v`",
+				humanSize(uint64(config.Memory)), humanSize(usage), nameOrId)
+		}
+	}
+	if ctx.IsSet("memory-swap") {
+		if config.MemorySwap, err = parseBytes(ctx.String("memory-swap")); err != nil {
+			return fmt.Errorf("bad memory-swap option: %w", err)
+		}
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	// The device rules don't change, and setting them again would deny all
+	// the devices for a moment with cgroup v1.
+	update := config
+	update.Devices = nil
+	if err := cgroup.Set(update); err != nil {
+		return err
+	}
+	info.Resources = config
+	return info.Save()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ExecUser is whom the process of a container runs as.
+type ExecUser struct {
+	Uid    int
+	Gid    int
+	Groups []int
+	Home   string
+}
+
+type passwdEntry struct {
+	name string
+	uid  int
+	gid  int
+	home string
+}
+
+type groupEntry struct {
+	name    string
+	gid     int
+	members []string
+}
+
+// lookupUser resolves a USER[:GROUP] spec, where each part is a name or a
+// numeric id, against /etc/passwd and /etc/group of the current root, i.e.
+// of the image once pivotRoot is done. A numeric user needs not exist.
+func lookupUser(spec string) (*ExecUser, error) {
+	userSpec, groupSpec := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		userSpec, groupSpec = spec[0:i], spec[i+1:]
+	}
+
+	users, err := readPasswd("/etc/passwd")
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readGroup("/etc/group")
+	if err != nil {
+		return nil, err
+	}
+
+	user := &ExecUser{Home: "/"}
+	var userName string
+	uid, uidErr := strconv.Atoi(userSpec)
+	found := false
+	for _, u := range users {
+		if (uidErr == nil && u.uid == uid) || (uidErr != nil && u.name == userSpec) {
+			user.Uid, user.Gid, user.Home, userName = u.uid, u.gid, u.home, u.name
+			found = true
+			break
+		}
+	}
+	if !found {
+		if uidErr != nil {
+			return nil, fmt.Errorf("no such user `%v` in the image", userSpec)
+		}
+		if uid < 0 {
+			return nil, fmt.Errorf("bad uid %v", uid)
+		}
+		user.Uid = uid
+	}
+
+	if groupSpec != "" {
+		gid, gidErr := strconv.Atoi(groupSpec)
+		found := false
+		for _, g := range groups {
+			if (gidErr == nil && g.gid == gid) || (gidErr != nil && g.name == groupSpec) {
+				user.Gid = g.gid
+				found = true
+				break
+			}
+		}
+		if !found {
+			if gidErr != nil {
+				return nil, fmt.Errorf("no such group `%v` in the image", groupSpec)
+			}
+			if gid < 0 {
+				return nil, fmt.Errorf("bad gid %v", gid)
+			}
+			user.Gid = gid
+		}
+	}
+
+	user.Groups = []int{user.Gid}
+	if userName != "" {
+		for _, g := range groups {
+			for _, member := range g.members {
+				if member == userName && g.gid != user.Gid {
+					user.Groups = append(user.Groups, g.gid)
+				}
+			}
+		}
+	}
+	return user, nil
+}
+
+// switchUser changes the credentials of the calling process.
+func switchUser(user *ExecUser) error {
+	if err := syscall.Setgroups(user.Groups); err != nil {
+		return fmt.Errorf("can't set groups: %w", err)
+	}
+	if err := syscall.Setgid(user.Gid); err != nil {
+		return fmt.Errorf("can't set gid: %w", err)
+	}
+	if err := syscall.Setuid(user.Uid); err != nil {
+		return fmt.Errorf("can't set uid: %w", err)
+	}
+	return nil
+}
+
+// readColonFile reads the lines of a passwd(5)-like file split into fields.
+// A missing file reads as empty.
+func readColonFile(filename string) ([][]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines [][]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, strings.Split(line, ":"))
+	}
+	return lines, scanner.Err()
+}
+
+func readPasswd(filename string) ([]passwdEntry, error) {
+	lines, err := readColonFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var entries []passwdEntry
+	for _, fields := range lines {
+		// name:password:uid:gid:gecos:home:shell
+		if len(fields) < 6 {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		gid, err := strconv.Atoi(fields[3])
+		if err != nil {
+			continue
+		}
+		entries = append(entries, passwdEntry{name: fields[0], uid: uid, gid: gid, home: fields[5]})
+	}
+	return entries, nil
+}
+
+func readGroup(filename string) ([]groupEntry, error) {
+	lines, err := readColonFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var entries []groupEntry
+	for _, fields := range lines {
+		// name:password:gid:members
+		if len(fields) < 4 {
+			continue
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		var members []string
+		if fields[3] != "" {
+			members = strings.Split(fields[3], ",")
+		}
+		entries = append(entries, groupEntry{name: fields[0], gid: gid, members: members})
+	}
+	return entries, nil
+}
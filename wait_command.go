@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"time"
+)
+
+var waitCommand = cli.Command{
+	Name:      "wait",
+	Usage:     "block until one or more containers stop, then print their exit codes",
+	UsageText: `mydocker wait CONTAINER...`,
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) == 0 {
+			return fmt.Errorf("missing container name")
+		}
+		for _, name := range ctx.Args() {
+			exitCode, err := waitContainer(name)
+			if err != nil {
+				return err
+			}
+			fmt.Println(exitCode)
+		}
+		return nil
+	},
+}
+
+// waitContainer polls the state of the container until it has exited, and
+// returns its exit code.
+func waitContainer(nameOrId string) (int, error) {
+	info, err := FindContainer(nameOrId)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if info.Status == StatusExited {
+			return info.ExitCode, nil
+		}
+		if info.Status == StatusCreated && !info.CreatorExists() {
+			// Killed before it could start the container or roll it back.
+			if err := finishContainer(info.Name, 0); err != nil {
+				return 0, err
+			}
+			return 0, fmt.Errorf("the container `%v` was never started", info.Name)
+		}
+		if (info.Status == StatusRunning || info.Status == StatusPaused) && !info.ProcessExists() {
+			// Nobody may be left to record the exit.
+			if err := reapContainer(info.Name, 0); err != nil {
+				return 0, err
+			}
+		} else {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if info, err = NewContainerInfo(info.Name); err != nil {
+			return 0, err
+		}
+	}
+}